@@ -0,0 +1,220 @@
+package adapter
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/pivotal-cf/on-demand-services-sdk/serviceadapter"
+	yaml "gopkg.in/yaml.v2"
+)
+
+//adapterConfigSettings operator wide settings service-adapter.conf may set besides profiles
+var adapterConfigSettings = []string{"auth", "credential_manager", "ingress", "syslog", "trusted_cas"}
+
+//tenantSettings plan properties tenants may replace through the arbitrary parameter of the same name,
+//once the tenant_settings plan property allows it
+var tenantSettings = []string{"syslog"}
+
+//AdapterConfig service-adapter.conf read from ManifestGenerator.ConfigPath. Settings have the name and shape of
+//the plan property they default, profiles are named sets of plan properties a plan pulls in by listing them
+//in its profiles property:
+//
+//  auth: {github: {client_id: ..., client_secret: ...}}
+//  credential_manager: {type: vault, url: https://vault.example.com, client_token: ...}
+//  ingress: {mode: load_balancer, external_url: "https://{deployment}.ci.example.com"}
+//  syslog: {address: logs.example.com, port: 6514, tls: true}
+//  trusted_cas: |
+//    -----BEGIN CERTIFICATE-----
+//  profiles:
+//    internal: {ingress: {mode: none}}
+//
+//director_credhub is no plan setting, the binder reads the variables of credhub plans through it
+type AdapterConfig struct {
+	Settings serviceadapter.Properties
+	Profiles map[string]serviceadapter.Properties
+	Credhub  *CredhubConfig
+}
+
+//loadAdapterConfig adapters without a config file behave as before, plans carry all their settings.
+//Unknown settings are logged and ignored, a newer config does not break an older adapter
+func loadAdapterConfig(configPath string, stderrLogger *log.Logger) (*AdapterConfig, error) {
+	config := &AdapterConfig{Settings: serviceadapter.Properties{}, Profiles: map[string]serviceadapter.Properties{}}
+	if configPath == "" {
+		return config, nil
+	}
+	content, err := ioutil.ReadFile(configPath)
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading adapter config %s: %s", configPath, err)
+	}
+	raw := map[string]interface{}{}
+	if err = yaml.Unmarshal(content, &raw); err != nil {
+		return nil, fmt.Errorf("adapter config %s is not valid yaml: %s", configPath, err)
+	}
+	if config.Credhub, err = parseCredhubConfig(jsonCompatible(raw).(map[string]interface{})); err != nil {
+		return nil, fmt.Errorf("adapter config %s: %s", configPath, err)
+	}
+	for key, value := range raw {
+		if key == "profiles" || key == "director_credhub" {
+			continue
+		}
+		if !containsString(adapterConfigSettings, key) {
+			if stderrLogger != nil {
+				stderrLogger.Printf("adapter config %s: ignoring unknown setting %s", configPath, key)
+			}
+			continue
+		}
+		config.Settings[key] = jsonCompatible(value)
+	}
+	if raw["profiles"] == nil {
+		return config, nil
+	}
+	profiles, ok := jsonCompatible(raw["profiles"]).(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("adapter config %s: profiles must map names to plan properties", configPath)
+	}
+	for name, value := range profiles {
+		profile, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("adapter config %s: profile %s must be a map of plan properties", configPath, name)
+		}
+		if _, nested := profile["profiles"]; nested {
+			return nil, fmt.Errorf("adapter config %s: profile %s cannot list further profiles", configPath, name)
+		}
+		config.Profiles[name] = profile
+	}
+	return config, nil
+}
+
+//planProperties effective plan properties. Each layer replaces whole settings of the layers before it,
+//a setting set to null is unset again:
+//
+//  1. settings of service-adapter.conf
+//  2. profiles listed in the profiles plan property, in the order listed
+//  3. the properties of the plan itself
+//  4. arbitrary parameters named like a tenant setting the tenant_settings plan property lists, currently syslog.
+//     Tenants cannot unset a setting
+//
+//A dedicated credhub replaces the credential_manager a plan inherits from the config file or its profiles
+func (c *AdapterConfig) planProperties(planProperties serviceadapter.Properties, arbitraryParams map[string]interface{}) (serviceadapter.Properties, error) {
+	properties := serviceadapter.Properties{}
+	for key, value := range c.Settings {
+		properties[key] = value
+	}
+	profileNames := []string{}
+	if _, err := decodePlanProperty(planProperties, "profiles", &profileNames); err != nil {
+		return nil, err
+	}
+	for _, name := range profileNames {
+		profile, found := c.Profiles[name]
+		if !found {
+			return nil, fmt.Errorf("profiles: %s is not defined in the adapter config, known profiles are %v", name, c.profileNames())
+		}
+		for key, value := range profile {
+			properties[key] = value
+		}
+	}
+	for key, value := range planProperties {
+		if key != "profiles" {
+			properties[key] = value
+		}
+	}
+	if _, explicit := planProperties["credential_manager"]; !explicit && dedicatedCredhubEnabled(properties) {
+		delete(properties, "credential_manager")
+	}
+	allowed, err := allowedTenantSettings(properties)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range tenantSettings {
+		value, ok := arbitraryParams[key]
+		if !ok {
+			continue
+		}
+		if !containsString(allowed, key) {
+			return nil, fmt.Errorf("%s: the plan does not let tenants change it", key)
+		}
+		if value == nil {
+			return nil, fmt.Errorf("%s: tenants cannot unset it", key)
+		}
+		properties[key] = value
+	}
+	for key, value := range properties {
+		if value == nil {
+			delete(properties, key)
+		}
+	}
+	return properties, nil
+}
+
+//allowedTenantSettings tenant_settings plan property, tenants change nothing unless the operator lists the setting
+func allowedTenantSettings(planProperties serviceadapter.Properties) ([]string, error) {
+	allowed := []string{}
+	if _, err := decodePlanProperty(planProperties, "tenant_settings", &allowed); err != nil {
+		return nil, err
+	}
+	for _, key := range allowed {
+		if !containsString(tenantSettings, key) {
+			return nil, fmt.Errorf("tenant_settings: tenants cannot change %s, only %v", key, tenantSettings)
+		}
+	}
+	return allowed, nil
+}
+
+func (c *AdapterConfig) profileNames() []string {
+	names := []string{}
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+//resolvePlan plan with the effective properties, the plan of the caller is left untouched
+func resolvePlan(configPath string, stderrLogger *log.Logger, plan serviceadapter.Plan, arbitraryParams map[string]interface{}) (serviceadapter.Plan, *AdapterConfig, error) {
+	config, err := loadAdapterConfig(configPath, stderrLogger)
+	if err != nil {
+		return plan, nil, err
+	}
+	plan.Properties, err = config.planProperties(plan.Properties, arbitraryParams)
+	return plan, config, err
+}
+
+//jsonCompatible yaml decodes maps keyed by interface{}, plan properties are decoded through encoding/json
+func jsonCompatible(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case map[interface{}]interface{}:
+		converted := map[string]interface{}{}
+		for key, item := range typed {
+			converted[fmt.Sprint(key)] = jsonCompatible(item)
+		}
+		return converted
+	case map[string]interface{}:
+		converted := map[string]interface{}{}
+		for key, item := range typed {
+			converted[key] = jsonCompatible(item)
+		}
+		return converted
+	case []interface{}:
+		converted := []interface{}{}
+		for _, item := range typed {
+			converted = append(converted, jsonCompatible(item))
+		}
+		return converted
+	}
+	return value
+}
+
+func containsString(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,295 @@
+package adapter
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pivotal-cf/on-demand-services-sdk/bosh"
+	"github.com/pivotal-cf/on-demand-services-sdk/serviceadapter"
+)
+
+//AuthConfig auth plan property, the login providers offered by instances of the plan
+type AuthConfig struct {
+	OIDC   *OIDCConnector   `json:"oidc"`
+	GitHub *GitHubConnector `json:"github"`
+	LDAP   *LDAPConnector   `json:"ldap"`
+}
+
+//OIDCConnector generic openid connect issuer
+type OIDCConnector struct {
+	DisplayName  string   `json:"display_name"`
+	Issuer       string   `json:"issuer"`
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret"`
+	Scopes       []string `json:"scopes"`
+	GroupsKey    string   `json:"groups_key"`
+	UserNameKey  string   `json:"user_name_key"`
+	CACert       string   `json:"ca_cert"`
+}
+
+//GitHubConnector github oauth application, host is only set for github enterprise
+type GitHubConnector struct {
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	Host         string `json:"host"`
+	CACert       string `json:"ca_cert"`
+}
+
+//LDAPConnector ldap server users and groups are looked up in
+type LDAPConnector struct {
+	DisplayName   string           `json:"display_name"`
+	Host          string           `json:"host"`
+	BindDN        string           `json:"bind_dn"`
+	BindPassword  string           `json:"bind_pw"`
+	InsecureNoSSL bool             `json:"insecure_no_ssl"`
+	CACert        string           `json:"ca_cert"`
+	UserSearch    LDAPUserSearch   `json:"user_search"`
+	GroupSearch   *LDAPGroupSearch `json:"group_search"`
+}
+
+//LDAPUserSearch how users are found by their login name
+type LDAPUserSearch struct {
+	BaseDN    string `json:"base_dn"`
+	Filter    string `json:"filter"`
+	Username  string `json:"username"`
+	IDAttr    string `json:"id_attr"`
+	EmailAttr string `json:"email_attr"`
+	NameAttr  string `json:"name_attr"`
+}
+
+//LDAPGroupSearch how the groups of a user are found
+type LDAPGroupSearch struct {
+	BaseDN    string `json:"base_dn"`
+	Filter    string `json:"filter"`
+	UserAttr  string `json:"user_attr"`
+	GroupAttr string `json:"group_attr"`
+	NameAttr  string `json:"name_attr"`
+}
+
+//MainTeamAuth main_team arbitrary parameter, who of the providers offered by the plan gets into the main team
+type MainTeamAuth struct {
+	OIDC   *GroupMembers  `json:"oidc"`
+	GitHub *GitHubMembers `json:"github"`
+	LDAP   *GroupMembers  `json:"ldap"`
+}
+
+//GroupMembers users and groups of an oidc or ldap provider
+type GroupMembers struct {
+	Users  []string `json:"users"`
+	Groups []string `json:"groups"`
+}
+
+//GitHubMembers github users, organizations and teams given as org:team
+type GitHubMembers struct {
+	Users []string `json:"users"`
+	Orgs  []string `json:"orgs"`
+	Teams []string `json:"teams"`
+}
+
+//parsePlanAuth validates the login providers offered by the plan, nil when it offers none.
+//Messages name the offending setting only, secrets of the plan never end up in an error
+func parsePlanAuth(generation concourseGeneration, planProperties serviceadapter.Properties) (*AuthConfig, error) {
+	auth := &AuthConfig{}
+	found, err := decodePlanProperty(planProperties, "auth", auth)
+	if err != nil || !found {
+		return nil, err
+	}
+	if generation == concourse3 {
+		return nil, fmt.Errorf("auth: login providers require concourse 4 or later")
+	}
+	if err = auth.validate(); err != nil {
+		return nil, err
+	}
+	return auth, nil
+}
+
+//parseMainTeam main team members requested for the login providers of the plan, nil when the parameter is not set
+func parseMainTeam(auth *AuthConfig, arbitraryParams map[string]interface{}) (*MainTeamAuth, error) {
+	mainTeam := &MainTeamAuth{}
+	requested, err := decodeParameter(arbitraryParams, "main_team", mainTeam)
+	if err != nil || !requested {
+		return nil, err
+	}
+	if auth == nil {
+		return nil, fmt.Errorf("main_team: the plan does not offer any login provider")
+	}
+	if err = mainTeam.validate("main_team", auth); err != nil {
+		return nil, err
+	}
+	return mainTeam, nil
+}
+
+func (a *AuthConfig) validate() error {
+	if a.OIDC != nil && (a.OIDC.Issuer == "" || a.OIDC.ClientID == "" || a.OIDC.ClientSecret == "") {
+		return fmt.Errorf("auth.oidc: issuer, client_id and client_secret must be set")
+	}
+	if a.GitHub != nil && (a.GitHub.ClientID == "" || a.GitHub.ClientSecret == "") {
+		return fmt.Errorf("auth.github: client_id and client_secret must be set")
+	}
+	if a.LDAP != nil && (a.LDAP.Host == "" || a.LDAP.UserSearch.BaseDN == "") {
+		return fmt.Errorf("auth.ldap: host and user_search.base_dn must be set")
+	}
+	return nil
+}
+
+//validate key names the setting the members are given in, main_team or a team of the teams parameter.
+//Every problem of the members is reported
+func (m *MainTeamAuth) validate(key string, auth *AuthConfig) error {
+	problems := []string{}
+	check := func(err error) {
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if m.OIDC != nil {
+		if auth.OIDC == nil {
+			problems = append(problems, fmt.Sprintf("%s.oidc: the plan does not offer oidc login", key))
+		}
+		check(validateMembers(key+".oidc.users", m.OIDC.Users))
+		check(validateMembers(key+".oidc.groups", m.OIDC.Groups))
+	}
+	if m.GitHub != nil {
+		if auth.GitHub == nil {
+			problems = append(problems, fmt.Sprintf("%s.github: the plan does not offer github login", key))
+		}
+		check(validateMembers(key+".github.users", m.GitHub.Users))
+		check(validateMembers(key+".github.orgs", m.GitHub.Orgs))
+		check(validateMembers(key+".github.teams", m.GitHub.Teams))
+		for _, team := range m.GitHub.Teams {
+			if parts := strings.SplitN(team, ":", 2); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+				problems = append(problems, fmt.Sprintf("%s.github.teams: %q must be given as org:team", key, team))
+			}
+		}
+	}
+	if m.LDAP != nil {
+		if auth.LDAP == nil {
+			problems = append(problems, fmt.Sprintf("%s.ldap: the plan does not offer ldap login", key))
+		}
+		check(validateMembers(key+".ldap.users", m.LDAP.Users))
+		check(validateMembers(key+".ldap.groups", m.LDAP.Groups))
+	}
+	return joinProblems(problems)
+}
+
+func validateMembers(key string, members []string) error {
+	for _, member := range members {
+		if strings.TrimSpace(member) == "" {
+			return fmt.Errorf("%s must not contain empty entries", key)
+		}
+	}
+	return nil
+}
+
+//authProperties connector settings of the web job and the members of the main team per connector.
+//Without a main_team parameter, updates keep the members the instance was deployed with
+func authProperties(auth *AuthConfig, mainTeam *MainTeamAuth, previousManifest *bosh.BoshManifest) (connectors map[string]interface{}, mainTeamAuth map[string]interface{}) {
+	connectors, mainTeamAuth = map[string]interface{}{}, map[string]interface{}{}
+	if auth == nil {
+		return
+	}
+	if mainTeam == nil {
+		mainTeam = previousMainTeamAuth(auth, previousManifest, mainTeamAuth)
+	}
+	if oidc := auth.OIDC; oidc != nil {
+		properties := map[string]interface{}{
+			"issuer":        oidc.Issuer,
+			"client_id":     oidc.ClientID,
+			"client_secret": oidc.ClientSecret,
+		}
+		setIfNotEmpty(properties, "display_name", oidc.DisplayName)
+		setIfNotEmpty(properties, "groups_key", oidc.GroupsKey)
+		setIfNotEmpty(properties, "user_name_key", oidc.UserNameKey)
+		if len(oidc.Scopes) > 0 {
+			properties["scopes"] = oidc.Scopes
+		}
+		setCACert(properties, oidc.CACert)
+		connectors["generic_oidc"] = properties
+		if mainTeam.OIDC != nil {
+			mainTeamAuth["oidc"] = groupMembersProperties(mainTeam.OIDC)
+		}
+	}
+	if github := auth.GitHub; github != nil {
+		properties := map[string]interface{}{
+			"client_id":     github.ClientID,
+			"client_secret": github.ClientSecret,
+		}
+		setIfNotEmpty(properties, "host", github.Host)
+		setCACert(properties, github.CACert)
+		connectors["github_auth"] = properties
+		if members := mainTeam.GitHub; members != nil {
+			mainTeamAuth["github"] = map[string]interface{}{
+				"users": nonNil(members.Users),
+				"orgs":  nonNil(members.Orgs),
+				"teams": nonNil(members.Teams),
+			}
+		}
+	}
+	if ldap := auth.LDAP; ldap != nil {
+		userSearch := map[string]interface{}{"base_dn": ldap.UserSearch.BaseDN}
+		setIfNotEmpty(userSearch, "filter", ldap.UserSearch.Filter)
+		setIfNotEmpty(userSearch, "username", ldap.UserSearch.Username)
+		setIfNotEmpty(userSearch, "id_attr", ldap.UserSearch.IDAttr)
+		setIfNotEmpty(userSearch, "email_attr", ldap.UserSearch.EmailAttr)
+		setIfNotEmpty(userSearch, "name_attr", ldap.UserSearch.NameAttr)
+		properties := map[string]interface{}{
+			"host":            ldap.Host,
+			"insecure_no_ssl": ldap.InsecureNoSSL,
+			"user_search":     userSearch,
+		}
+		setIfNotEmpty(properties, "display_name", ldap.DisplayName)
+		setIfNotEmpty(properties, "bind_dn", ldap.BindDN)
+		setIfNotEmpty(properties, "bind_pw", ldap.BindPassword)
+		setCACert(properties, ldap.CACert)
+		if groupSearch := ldap.GroupSearch; groupSearch != nil {
+			search := map[string]interface{}{}
+			setIfNotEmpty(search, "base_dn", groupSearch.BaseDN)
+			setIfNotEmpty(search, "filter", groupSearch.Filter)
+			setIfNotEmpty(search, "user_attr", groupSearch.UserAttr)
+			setIfNotEmpty(search, "group_attr", groupSearch.GroupAttr)
+			setIfNotEmpty(search, "name_attr", groupSearch.NameAttr)
+			properties["group_search"] = search
+		}
+		connectors["ldap_auth"] = properties
+		if mainTeam.LDAP != nil {
+			mainTeamAuth["ldap"] = groupMembersProperties(mainTeam.LDAP)
+		}
+	}
+	return
+}
+
+//previousMainTeamAuth copies the members of the connectors still offered by the plan into mainTeamAuth
+func previousMainTeamAuth(auth *AuthConfig, previousManifest *bosh.BoshManifest, mainTeamAuth map[string]interface{}) *MainTeamAuth {
+	offered := map[string]bool{"oidc": auth.OIDC != nil, "github": auth.GitHub != nil, "ldap": auth.LDAP != nil}
+	for connector, isOffered := range offered {
+		if previous, found := previousProperty(previousManifest, WebInstanceName, "main_team", "auth", connector); found && isOffered {
+			mainTeamAuth[connector] = previous
+		}
+	}
+	return &MainTeamAuth{}
+}
+
+func groupMembersProperties(members *GroupMembers) map[string]interface{} {
+	return map[string]interface{}{
+		"users":  nonNil(members.Users),
+		"groups": nonNil(members.Groups),
+	}
+}
+
+func setIfNotEmpty(properties map[string]interface{}, key string, value string) {
+	if value != "" {
+		properties[key] = value
+	}
+}
+
+func setCACert(properties map[string]interface{}, caCert string) {
+	if caCert != "" {
+		properties["ca_cert"] = map[string]interface{}{"certificate": caCert}
+	}
+}
+
+func nonNil(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
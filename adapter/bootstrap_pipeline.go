@@ -0,0 +1,128 @@
+package adapter
+
+import (
+	"fmt"
+
+	"github.com/pivotal-cf/on-demand-services-sdk/bosh"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	//BootstrapErrandInstanceName plan instance group running the bootstrap pipeline errand, declare it with lifecycle errand and as post_deploy errand
+	BootstrapErrandInstanceName = "bootstrap-pipeline"
+	//SetPipelineJobName errand job of the concourse-errands release setting and unpausing a pipeline, the way fly set-pipeline and unpause-pipeline do
+	SetPipelineJobName = "set-pipeline"
+	//DefaultBootstrapPipelineName name of the bootstrap pipeline when the parameter does not name one
+	DefaultBootstrapPipelineName = "bootstrap"
+)
+
+//BootstrapPipeline bootstrap_pipeline parameter, the config is either given inline or read from a git repository
+type BootstrapPipeline struct {
+	Name   string                 `json:"name"`
+	Config string                 `json:"config"`
+	Git    *PipelineSource        `json:"git"`
+	Vars   map[string]interface{} `json:"vars"`
+	//varsReference placeholder of the vars stored in credhub
+	varsReference string
+}
+
+//PipelineSource pipeline config file in a git repository, private_key is only needed for ssh uris
+type PipelineSource struct {
+	URI        string `json:"uri"`
+	Branch     string `json:"branch"`
+	Path       string `json:"path"`
+	PrivateKey string `json:"private_key"`
+}
+
+//parseBootstrapPipeline pipeline requested through the bootstrap_pipeline parameter, nil when the parameter is not set
+func parseBootstrapPipeline(arbitraryParams map[string]interface{}) (*BootstrapPipeline, error) {
+	pipeline := &BootstrapPipeline{}
+	requested, err := decodeParameter(arbitraryParams, "bootstrap_pipeline", pipeline)
+	if err != nil || !requested {
+		return nil, err
+	}
+	if pipeline.Name == "" {
+		pipeline.Name = DefaultBootstrapPipelineName
+	}
+	problems := []string{}
+	if !validTeamName.MatchString(pipeline.Name) {
+		problems = append(problems, fmt.Sprintf("bootstrap_pipeline: name %q may only contain letters, digits, '_' and '-'", pipeline.Name))
+	}
+	if (pipeline.Config == "") == (pipeline.Git == nil) {
+		problems = append(problems, "bootstrap_pipeline: exactly one of config and git must be set")
+	}
+	if pipeline.Config != "" {
+		config := map[string]interface{}{}
+		if err = yaml.Unmarshal([]byte(pipeline.Config), &config); err != nil {
+			problems = append(problems, fmt.Sprintf("bootstrap_pipeline.config is not valid yaml: %s", err))
+		}
+	}
+	if pipeline.Git != nil && (pipeline.Git.URI == "" || pipeline.Git.Path == "") {
+		problems = append(problems, "bootstrap_pipeline.git: uri and path must be set")
+	}
+	if err = joinProblems(problems); err != nil {
+		return nil, err
+	}
+	return pipeline, nil
+}
+
+//bootstrapPipelineSecrets the private_key of the git source and the vars, stored under
+//<prefix>/<deployment>/bootstrap_pipeline/ on credhub plans
+func (c *credentials) bootstrapPipelineSecrets(pipeline *BootstrapPipeline) *BootstrapPipeline {
+	if pipeline == nil || !c.useCredhub() {
+		return pipeline
+	}
+	stored := *pipeline
+	if pipeline.Git != nil && pipeline.Git.PrivateKey != "" {
+		git := *pipeline.Git
+		git.PrivateKey = c.store("bootstrap_pipeline/git_private_key", ValueVariableType, git.PrivateKey).(string)
+		stored.Git = &git
+	}
+	if len(pipeline.Vars) > 0 {
+		stored.varsReference = c.store("bootstrap_pipeline/vars", JSONVariableType, pipeline.Vars).(string)
+		stored.Vars = nil
+	}
+	return &stored
+}
+
+//bootstrapErrandProperties the errand sets the pipeline on the main team and unpauses it. Deploys without the parameter
+//hand the previous pipeline on with overwrite off, so upgrades do not revert changes made to the pipeline since
+func bootstrapErrandProperties(pipeline *BootstrapPipeline, secrets concourseSecrets, externalURL string, previousManifest *bosh.BoshManifest) map[string]interface{} {
+	properties := map[string]interface{}{
+		"concourse": concourseAPIProperties(secrets, externalURL),
+		"team":      "main",
+		"unpause":   true,
+		"overwrite": pipeline != nil,
+	}
+	if pipeline == nil {
+		if previous, found := previousProperty(previousManifest, BootstrapErrandInstanceName, "pipeline"); found {
+			properties["pipeline"] = previous
+		}
+		return properties
+	}
+
+	pipelineProperties := map[string]interface{}{
+		"name": pipeline.Name,
+		"vars": pipeline.Vars,
+	}
+	if pipeline.Vars == nil {
+		pipelineProperties["vars"] = map[string]interface{}{}
+	}
+	if pipeline.varsReference != "" {
+		pipelineProperties["vars"] = pipeline.varsReference
+	}
+	if pipeline.Config != "" {
+		pipelineProperties["config"] = pipeline.Config
+	}
+	if git := pipeline.Git; git != nil {
+		source := map[string]interface{}{
+			"uri":  git.URI,
+			"path": git.Path,
+		}
+		setIfNotEmpty(source, "branch", git.Branch)
+		setIfNotEmpty(source, "private_key", git.PrivateKey)
+		pipelineProperties["git"] = source
+	}
+	properties["pipeline"] = pipelineProperties
+	return properties
+}
@@ -0,0 +1,172 @@
+package adapter
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+//CertificateValidity lifetime of generated certificates
+const CertificateValidity = 2 * 365 * 24 * time.Hour
+
+//CurrentCertificateGenerator issues server certificates, signed by the given CA or by a new one when it is nil
+var CurrentCertificateGenerator = x509CertificateGenerator
+
+//Certificate PEM encoded certificate, its private key and the certificate of the issuing CA
+type Certificate struct {
+	Certificate string
+	PrivateKey  string
+	CA          string
+}
+
+//CertificateAuthority PEM encoded CA used to sign server certificates
+type CertificateAuthority struct {
+	Certificate string
+	PrivateKey  string
+}
+
+func x509CertificateGenerator(commonName string, ca *CertificateAuthority) (Certificate, error) {
+	var err error
+	if ca == nil {
+		ca, err = generateCertificateAuthority(commonName + " CA")
+		if err != nil {
+			return Certificate{}, err
+		}
+	}
+	return issueCertificate(*ca, pkix.Name{CommonName: commonName}, []string{commonName}, x509.ExtKeyUsageServerAuth)
+}
+
+//issueCertificate signs a certificate for the subject and dns names with the CA
+func issueCertificate(ca CertificateAuthority, subject pkix.Name, dnsNames []string, usages ...x509.ExtKeyUsage) (Certificate, error) {
+	caCertificate, caKey, err := parseCertificateAuthority(ca)
+	if err != nil {
+		return Certificate{}, err
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return Certificate{}, err
+	}
+	template, err := certificateTemplate(subject.CommonName)
+	if err != nil {
+		return Certificate{}, err
+	}
+	template.Subject = subject
+	template.DNSNames = dnsNames
+	template.KeyUsage = x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
+	template.ExtKeyUsage = usages
+	der, err := x509.CreateCertificate(rand.Reader, template, caCertificate, &key.PublicKey, caKey)
+	if err != nil {
+		return Certificate{}, err
+	}
+	return Certificate{
+		Certificate: encodeCertificate(der),
+		PrivateKey:  encodePrivateKey(key),
+		CA:          ca.Certificate,
+	}, nil
+}
+
+func generateCertificateAuthority(commonName string) (*CertificateAuthority, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	template, err := certificateTemplate(commonName)
+	if err != nil {
+		return nil, err
+	}
+	template.IsCA = true
+	template.BasicConstraintsValid = true
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	return &CertificateAuthority{
+		Certificate: encodeCertificate(der),
+		PrivateKey:  encodePrivateKey(key),
+	}, nil
+}
+
+func certificateTemplate(commonName string) (*x509.Certificate, error) {
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	return &x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(CertificateValidity),
+	}, nil
+}
+
+func parseCertificateAuthority(ca CertificateAuthority) (*x509.Certificate, *rsa.PrivateKey, error) {
+	certificate, err := parseCertificate(ca.Certificate)
+	if err != nil {
+		return nil, nil, fmt.Errorf("ca certificate: %s", err)
+	}
+	keyBlock, _ := pem.Decode([]byte(ca.PrivateKey))
+	if keyBlock == nil {
+		return nil, nil, fmt.Errorf("ca private key: no PEM data found")
+	}
+	key, err := x509.ParsePKCS1PrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("ca private key: %s", err)
+	}
+	return certificate, key, nil
+}
+
+func parseCertificate(certificatePEM string) (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(certificatePEM))
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+//certificateValidFor previous certificates are kept while they match the host and are not about to expire
+func certificateValidFor(certificatePEM string, host string) bool {
+	certificate, err := parseCertificate(certificatePEM)
+	if err != nil {
+		return false
+	}
+	if expiresSoon(certificate) {
+		return false
+	}
+	return certificate.VerifyHostname(host) == nil
+}
+
+//certificateIssuedBy previous certificates are only kept while they verify against the current CA
+func certificateIssuedBy(certificatePEM string, caPEM string) bool {
+	certificate, err := parseCertificate(certificatePEM)
+	if err != nil {
+		return false
+	}
+	ca, err := parseCertificate(caPEM)
+	return err == nil && certificate.CheckSignatureFrom(ca) == nil
+}
+
+//clientCertificateValid previous client certificates are kept while they are not about to expire
+func clientCertificateValid(certificatePEM string) bool {
+	certificate, err := parseCertificate(certificatePEM)
+	return err == nil && !expiresSoon(certificate)
+}
+
+func expiresSoon(certificate *x509.Certificate) bool {
+	return time.Now().Add(30 * 24 * time.Hour).After(certificate.NotAfter)
+}
+
+func encodeCertificate(der []byte) string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+func encodePrivateKey(key *rsa.PrivateKey) string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}))
+}
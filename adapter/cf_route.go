@@ -0,0 +1,153 @@
+package adapter
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pivotal-cf/on-demand-services-sdk/serviceadapter"
+)
+
+const (
+	//DefaultRouteName name atc is registered under with the gorouter
+	DefaultRouteName = "concourse-service"
+	//DefaultRouteRegistrationInterval how often route_registrar renews the routes
+	DefaultRouteRegistrationInterval = "20s"
+	//DefaultRouteScheme scheme of the external url, the gorouter terminates tls
+	DefaultRouteScheme = "https"
+	//DefaultRouteHostname hostname of the instance below app_domain
+	DefaultRouteHostname = ExternalURLDeploymentToken
+	//DefaultNATSLink link route_registrar consumes from the cf deployment
+	DefaultNATSLink = "nats"
+	//DefaultNATSTLSLink link of the tls enabled nats of newer cf deployments
+	DefaultNATSTLSLink = "nats-tls"
+)
+
+//CFRoute cf_route plan property, how gorouter ingress registers atc. Unset fields keep the defaults
+type CFRoute struct {
+	Name                 string `json:"name"`
+	Port                 int    `json:"port"`
+	RegistrationInterval string `json:"registration_interval"`
+	Scheme               string `json:"scheme"`
+	//Hostname template of the hostname below app_domain, must contain {deployment}
+	Hostname string `json:"hostname"`
+	//AdditionalURIs templates of further uris routed to atc, must contain {deployment}
+	AdditionalURIs []string `json:"additional_uris"`
+	//RouterGroup router group of the route on foundations sharding their routers
+	RouterGroup string   `json:"router_group"`
+	NATS        NATSLink `json:"nats"`
+}
+
+//NATSLink nats route_registrar announces the routes on, isolation segments with their own routers
+//name the deployment providing their nats
+type NATSLink struct {
+	Link       string   `json:"link"`
+	From       string   `json:"from"`
+	Deployment string   `json:"deployment"`
+	TLS        *NATSTLS `json:"tls"`
+}
+
+//NATSTLS client certificate route_registrar presents to nats-tls, usually credhub references of the cf deployment
+type NATSTLS struct {
+	ClientCert string `json:"client_cert"`
+	ClientKey  string `json:"client_key"`
+}
+
+func parseCFRoute(planProperties serviceadapter.Properties) (*CFRoute, error) {
+	route := &CFRoute{}
+	if _, err := decodePlanProperty(planProperties, "cf_route", route); err != nil {
+		return nil, err
+	}
+	if route.Name == "" {
+		route.Name = DefaultRouteName
+	}
+	if route.Port == 0 {
+		route.Port = AtcPort
+	}
+	if route.RegistrationInterval == "" {
+		route.RegistrationInterval = DefaultRouteRegistrationInterval
+	}
+	if route.Scheme == "" {
+		route.Scheme = DefaultRouteScheme
+	}
+	if route.Hostname == "" {
+		route.Hostname = DefaultRouteHostname
+	}
+	defaultLink := DefaultNATSLink
+	if route.NATS.TLS != nil {
+		defaultLink = DefaultNATSTLSLink
+	}
+	if route.NATS.Link == "" {
+		route.NATS.Link = defaultLink
+	}
+	if route.NATS.From == "" {
+		route.NATS.From = defaultLink
+	}
+	if route.NATS.Deployment == "" {
+		route.NATS.Deployment, _ = planProperties["cf_deployment"].(string)
+	}
+
+	if route.Port < 0 || route.Port > 65535 || route.Port == AtcTLSPort || route.Port == TsaPort {
+		return nil, fmt.Errorf("cf_route.port must be a free port")
+	}
+	if interval, err := time.ParseDuration(route.RegistrationInterval); err != nil || interval <= 0 {
+		return nil, fmt.Errorf("cf_route.registration_interval must be a duration such as %s", DefaultRouteRegistrationInterval)
+	}
+	if route.Scheme != "http" && route.Scheme != "https" {
+		return nil, fmt.Errorf("cf_route.scheme must be http or https")
+	}
+	for _, uri := range append([]string{route.Hostname}, route.AdditionalURIs...) {
+		if !strings.Contains(uri, ExternalURLDeploymentToken) {
+			return nil, fmt.Errorf("cf_route uri %q must contain %s to tell instances apart", uri, ExternalURLDeploymentToken)
+		}
+	}
+	if tls := route.NATS.TLS; tls != nil && (tls.ClientCert == "" || tls.ClientKey == "") {
+		return nil, fmt.Errorf("cf_route.nats.tls.client_cert and cf_route.nats.tls.client_key must be set")
+	}
+	return route, nil
+}
+
+//host hostname of the instance below the app domain
+func (r *CFRoute) host(deploymentName string, appDomain string) string {
+	return fmt.Sprintf("%s.%s", strings.Replace(r.Hostname, ExternalURLDeploymentToken, deploymentName, -1), appDomain)
+}
+
+//additionalURIs the additional uris of the instance
+func (r *CFRoute) additionalURIs(deploymentName string) []string {
+	uris := []string{}
+	for _, uri := range r.AdditionalURIs {
+		uris = append(uris, strings.Replace(uri, ExternalURLDeploymentToken, deploymentName, -1))
+	}
+	return uris
+}
+
+//routeRegistrarProperties route_registrar properties of the web route, host is the hostname the certificate is issued for
+func (r *CFRoute) routeRegistrarProperties(host string, uris []string, tlsEnabled bool) map[string]interface{} {
+	route := map[string]interface{}{
+		"name":                  r.Name,
+		"port":                  r.Port,
+		"registration_interval": r.RegistrationInterval,
+		"uris":                  uris,
+	}
+	if tlsEnabled {
+		delete(route, "port")
+		route["tls_port"] = AtcTLSPort
+		route["server_cert_domain_san"] = host
+	}
+	if r.RouterGroup != "" {
+		route["router_group"] = r.RouterGroup
+	}
+	routeRegistrar := map[string]interface{}{
+		"routes": []map[string]interface{}{route},
+	}
+	if tls := r.NATS.TLS; tls != nil {
+		routeRegistrar["nats"] = map[string]interface{}{
+			"tls": map[string]interface{}{
+				"enabled":     true,
+				"client_cert": tls.ClientCert,
+				"client_key":  tls.ClientKey,
+			},
+		}
+	}
+	return routeRegistrar
+}
@@ -1,7 +1,9 @@
 package adapter
 
 import (
+	"fmt"
 	"log"
+	"strings"
 
 	"github.com/pivotal-cf/on-demand-services-sdk/bosh"
 	"github.com/pivotal-cf/on-demand-services-sdk/serviceadapter"
@@ -10,22 +12,156 @@ import (
 //Binder Implementation for binding contract
 type Binder struct {
 	StderrLogger *log.Logger
+	//WorkerKeyStore records the keys of external workers requested through bind parameters
+	WorkerKeyStore WorkerKeyStore
+	//ConfigPath service-adapter.conf, its director_credhub is read to bind instances of credhub plans
+	ConfigPath     string
+	SecretResolver SecretResolver
 }
 
-//CreateBinding Contract on cf bind-service
+//CreateBinding Contract on cf bind-service, secrets kept in credhub are resolved into the binding
 func (b Binder) CreateBinding(bindingID string, deploymentTopology bosh.BoshVMs, manifest bosh.BoshManifest, requestParams serviceadapter.RequestParameters) (serviceadapter.Binding, error) {
+	if err := validateParameters(bindingParameterSchema(), requestParams.ArbitraryParams()); err != nil {
+		return serviceadapter.Binding{}, err
+	}
 	prop := manifest.InstanceGroups[0].Properties
+	username, password := prop["basic_auth_username"], prop["basic_auth_password"]
+	if localUser, found := lookupProperty(prop, "add_local_users", 0); found {
+		if parts := strings.SplitN(fmt.Sprint(localUser), ":", 2); len(parts) == 2 {
+			username, password = parts[0], parts[1]
+		}
+	}
+	resolvedPassword, err := b.resolveCredential(password)
+	if err != nil {
+		return serviceadapter.Binding{}, err
+	}
+	credentials := map[string]interface{}{
+		"username": username,
+		"password": resolvedPassword,
+		"host":     prop["external_url"],
+	}
+	if caCert, found := lookupProperty(prop, "tls_ca", "certificate"); found {
+		if credentials["ca_cert"], err = b.resolveCredential(caCert); err != nil {
+			return serviceadapter.Binding{}, err
+		}
+	}
+	if externalHost, found := lookupProperty(prop, "external_tsa", "host"); found {
+		credentials["tsa_external_host"] = externalHost
+		credentials["tsa_external_port"], _ = lookupProperty(prop, "external_tsa", "port")
+	}
+
+	externalWorker, err := externalWorkerRequested(requestParams)
+	if err != nil {
+		return serviceadapter.Binding{}, err
+	}
+	if externalWorker {
+		workerCredentials, err := b.registerExternalWorker(bindingID, manifest)
+		if err != nil {
+			return serviceadapter.Binding{}, err
+		}
+		for key, value := range workerCredentials {
+			credentials[key] = value
+		}
+	}
+
 	return serviceadapter.Binding{
-		Credentials: map[string]interface{}{
-			"username": prop["basic_auth_username"],
-			"password": prop["basic_auth_password"],
-			"host":     prop["external_url"],
-		},
+		Credentials: credentials,
 	}, nil
 
 }
 
-//DeleteBinding Static credentials no neeed to do anything
+//DeleteBinding Static credentials no neeed to do anything, the key of an external worker is revoked by the next deploy
 func (b Binder) DeleteBinding(bindingID string, deploymentTopology bosh.BoshVMs, manifest bosh.BoshManifest, requestParams serviceadapter.RequestParameters) error {
-	return nil
+	if b.WorkerKeyStore == nil {
+		return nil
+	}
+	return b.WorkerKeyStore.Remove(manifest.Name, bindingID)
+}
+
+func externalWorkerRequested(requestParams serviceadapter.RequestParameters) (bool, error) {
+	value, ok := requestParams.ArbitraryParams()["external_worker"]
+	if !ok {
+		return false, nil
+	}
+	externalWorker, ok := value.(bool)
+	if !ok {
+		return false, fmt.Errorf("external_worker must be a boolean")
+	}
+	return externalWorker, nil
+}
+
+//WorkerKeyNotice bindings hand out worker keys before the tsa accepts them, the broker cannot deploy on bind
+const WorkerKeyNotice = "the tsa accepts worker_private_key once the service instance is updated, run cf update-service for the instance before starting the worker"
+
+//registerExternalWorker hands out a fresh worker key, it is authorized by the next deploy of the service instance
+func (b Binder) registerExternalWorker(bindingID string, manifest bosh.BoshManifest) (map[string]interface{}, error) {
+	if b.WorkerKeyStore == nil {
+		return nil, fmt.Errorf("external workers are not supported by this broker")
+	}
+	prop := manifest.InstanceGroups[0].Properties
+	hostPublicKey, found := lookupProperty(prop, "worker_gateway", "host_key", "public_key")
+	if !found {
+		hostPublicKey, found = lookupProperty(prop, "tsa", "host_public_key")
+	}
+	if !found {
+		return nil, fmt.Errorf("no tsa host key found in the manifest of %s", manifest.Name)
+	}
+	tsaHost, tsaPort, found := tsaAddress(manifest)
+	if !found {
+		return nil, fmt.Errorf("external workers cannot reach the tsa of %s, its plan needs a tsa_tcp_route", manifest.Name)
+	}
+	hostPublicKey, err := b.resolveCredential(hostPublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	workerKey, err := CurrentKeyPairGenerator()
+	if err != nil {
+		return nil, err
+	}
+	if err = b.WorkerKeyStore.Add(manifest.Name, bindingID, workerKey.SSHPublicKey); err != nil {
+		b.StderrLogger.Printf("storing worker key of binding %s: %s", bindingID, err)
+		return nil, err
+	}
+	b.StderrLogger.Printf("worker key of binding %s is authorized by the next deploy of %s", bindingID, manifest.Name)
+	return map[string]interface{}{
+		"tsa_host":            tsaHost,
+		"tsa_port":            tsaPort,
+		"tsa_host_public_key": hostPublicKey,
+		"worker_private_key":  workerKey.PrivateKey,
+		"worker_key_notice":   WorkerKeyNotice,
+	}, nil
+}
+
+//resolveCredential literal values are returned as is, ((placeholders)) are read from the credhub of the director
+func (b Binder) resolveCredential(value interface{}) (interface{}, error) {
+	variableName, ok := credhubReference(value)
+	if !ok {
+		return value, nil
+	}
+	config, err := loadAdapterConfig(b.ConfigPath, b.StderrLogger)
+	if err != nil {
+		return nil, err
+	}
+	if config.Credhub == nil || b.SecretResolver == nil {
+		return nil, fmt.Errorf("binding instances of credhub plans requires director_credhub in the adapter config")
+	}
+	resolved, err := b.SecretResolver.Resolve(*config.Credhub, variableName)
+	if err != nil {
+		b.StderrLogger.Printf("resolving %s: %s", variableName, err)
+		return nil, err
+	}
+	return resolved, nil
+}
+
+//tsaAddress workers outside the deployment register through the tcp router, the bosh dns address of the web vms
+//cannot be resolved outside the director so instances without a tsa_tcp_route have no address to hand out
+func tsaAddress(manifest bosh.BoshManifest) (interface{}, interface{}, bool) {
+	webProperties := manifest.InstanceGroups[0].Properties
+	host, found := lookupProperty(webProperties, "external_tsa", "host")
+	if !found {
+		return nil, nil, false
+	}
+	port, _ := lookupProperty(webProperties, "external_tsa", "port")
+	return host, port, true
 }
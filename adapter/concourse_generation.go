@@ -0,0 +1,132 @@
+package adapter
+
+import (
+	"github.com/pivotal-cf/on-demand-services-sdk/bosh"
+	"github.com/pivotal-cf/on-demand-services-sdk/serviceadapter"
+)
+
+//concourseGeneration the job topology offered by the supplied concourse release
+type concourseGeneration int
+
+const (
+	//concourse3 atc/tsa on web, groundcrew/baggageclaim/garden-runc on workers, postgresql from the concourse release
+	concourse3 concourseGeneration = iota
+	//concourse4 single web and worker jobs, bpm and the postgres release
+	concourse4
+)
+
+//detectGeneration newer concourse releases ship a web job instead of atc/tsa
+func detectGeneration(releases serviceadapter.ServiceReleases) concourseGeneration {
+	if releasesProvideJob(releases, WebJobName) {
+		return concourse4
+	}
+	return concourse3
+}
+
+//previousGeneration the layout an existing deployment was generated with, the current one on fresh deployments
+func previousGeneration(previousManifest *bosh.BoshManifest, current concourseGeneration) concourseGeneration {
+	webInstanceGroup := findPreviousInstanceGroup(previousManifest, WebInstanceName)
+	if webInstanceGroup == nil {
+		return current
+	}
+	for _, job := range webInstanceGroup.Jobs {
+		switch job.Name {
+		case AtcJobName:
+			return concourse3
+		case WebJobName:
+			return concourse4
+		}
+	}
+	return current
+}
+
+//previousDBGeneration the database job of an existing db instance group. Instances migrated from concourse 3
+//keep the postgresql job, the postgres job of the postgres release keeps its data in another directory
+//and would start with an empty database
+func previousDBGeneration(previousManifest *bosh.BoshManifest, current concourseGeneration) concourseGeneration {
+	dbInstanceGroup := findPreviousInstanceGroup(previousManifest, DatabaseInstanceName)
+	if dbInstanceGroup == nil {
+		return current
+	}
+	for _, job := range dbInstanceGroup.Jobs {
+		switch job.Name {
+		case PostgresJobName:
+			return concourse3
+		case PostgresServerJobName:
+			return concourse4
+		}
+	}
+	return current
+}
+
+func releasesProvideJob(releases serviceadapter.ServiceReleases, jobName string) bool {
+	for _, release := range releases {
+		for _, providedJob := range release.Jobs {
+			if providedJob == jobName {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (g concourseGeneration) webJobs() []string {
+	if g == concourse4 {
+		return []string{WebJobName, BpmJobName, RouteRegisterJobName}
+	}
+	return []string{AtcJobName, TsaJobName, RouteRegisterJobName}
+}
+
+//linkWebJobs on concourse 3 every tsa forwards workers to all atc nodes of the deployment
+func (g concourseGeneration) linkWebJobs(jobs []bosh.Job) {
+	if g != concourse3 {
+		return
+	}
+	atc, tsa := findJob(jobs, AtcJobName), findJob(jobs, TsaJobName)
+	if atc == nil || tsa == nil {
+		return
+	}
+	atc.Provides["atc"] = bosh.ProvidesLink{As: "atc"}
+	tsa.AddConsumesLink("atc", "atc")
+}
+
+func (g concourseGeneration) dbJobs() []string {
+	if g == concourse4 {
+		return []string{PostgresServerJobName}
+	}
+	return []string{PostgresJobName}
+}
+
+func (g concourseGeneration) workerJobs() []string {
+	if g == concourse4 {
+		return []string{WorkerJobName}
+	}
+	return []string{GroundCrewJobName, BaggageClaimJobName, GardenJobName}
+}
+
+//previousAdminPassword basic auth password of 3.x, local user password from 4.x on
+func (g concourseGeneration) previousAdminPassword(previousManifest *bosh.BoshManifest) (string, bool) {
+	if g == concourse4 {
+		return previousLocalUserPassword(previousManifest, WebInstanceName, AdminUsername)
+	}
+	return previousStringProperty(previousManifest, WebInstanceName, "basic_auth_password")
+}
+
+//dbPasswordPath where the db instance group keeps the atc role password
+func (g concourseGeneration) dbPasswordPath() []interface{} {
+	if g == concourse4 {
+		return []interface{}{"databases", "roles", 0, "password"}
+	}
+	return []interface{}{"databases", 0, "password"}
+}
+
+//previousDatabase database and role name the db instance group was deployed with
+func (g concourseGeneration) previousDatabase(previousManifest *bosh.BoshManifest) (name string, role string, found bool) {
+	namePath, rolePath := []interface{}{"databases", 0, "name"}, []interface{}{"databases", 0, "role"}
+	if g == concourse4 {
+		namePath, rolePath = []interface{}{"databases", "databases", 0, "name"}, []interface{}{"databases", "roles", 0, "name"}
+	}
+	name, nameFound := previousStringProperty(previousManifest, DatabaseInstanceName, namePath...)
+	role, roleFound := previousStringProperty(previousManifest, DatabaseInstanceName, rolePath...)
+	return name, role, nameFound && roleFound
+}
@@ -38,6 +38,22 @@ const (
 	GardenJobName = "garden"
 	//RouteRegisterJobName route register job name
 	RouteRegisterJobName = "route_registrar"
+	//BpmReleaseName name of the bpm release
+	BpmReleaseName = "bpm"
+	//PostgresReleaseName name of the postgres release
+	PostgresReleaseName = "postgres"
+	//WebJobName web job name of concourse 4 and later
+	WebJobName = "web"
+	//WorkerJobName worker job name of concourse 4 and later
+	WorkerJobName = "worker"
+	//BpmJobName bpm job name
+	BpmJobName = "bpm"
+	//PostgresServerJobName postgres job name of the postgres release
+	PostgresServerJobName = "postgres"
+	//TsaPort port workers register through
+	TsaPort = 2222
+	//AdminUsername user of the main team handed out by bindings
+	AdminUsername = "atc"
 )
 
 //CurrentPasswordGenerator Password Generator
@@ -48,6 +64,16 @@ type ManifestGenerator struct {
 	StderrLogger           *log.Logger
 	ConfigPath             string
 	RedisInstanceGroupName string
+	//DatabaseProvisioner creates per instance databases on plans with an external_database
+	DatabaseProvisioner DatabaseProvisioner
+	//WorkerKeyStore public keys of external workers registered through bindings
+	WorkerKeyStore WorkerKeyStore
+	//ClientRegistrar creates the uaa client of instances on plans with uaa login
+	ClientRegistrar ClientRegistrar
+	//PortAllocator external tcp router ports of instances on plans with a tsa_tcp_route
+	PortAllocator PortAllocator
+	//SecretStore keeps the passwords tenants choose on credhub plans in the credhub of the director
+	SecretStore SecretStore
 }
 
 func mapNetworksToBoshNetworks(networks []string) []bosh.Network {
@@ -62,7 +88,42 @@ func getProperties(plan serviceadapter.Plan) map[string]interface{} {
 	return plan.Properties
 }
 
-//GenerateManifest Generate a bosh manifest. Cloud Controller will pass in the arguments
+//concourseDatabase the database atc connects to, external is only set for operator managed servers
+type concourseDatabase struct {
+	name     string
+	role     string
+	password string
+	external *ExternalDatabase
+	//host address of a db instance group web cannot link to, only set for the postgresql job of concourse 3 under concourse 4
+	host string
+}
+
+//concourseSecrets credentials shared between the instance groups of a deployment
+type concourseSecrets struct {
+	adminPassword   string
+	database        concourseDatabase
+	tsaHostKey      map[string]interface{}
+	workerKey       map[string]interface{}
+	tokenSigningKey map[string]interface{}
+	//webCertificate certificate and private_key atc serves https with, nil without tls
+	webCertificate map[string]interface{}
+	//webCA certificate of the CA clients verify atc with, with its private_key when the adapter generated it
+	webCA map[string]interface{}
+	//cfLogin uaa client and space of the main team, nil without uaa login
+	cfLogin *cfLogin
+	//credhub dedicated credhub of the instance, nil unless the plan enables it
+	credhub *credhubSecrets
+	//externalTSA tcp router address of the tsa, nil without tsa_tcp_route
+	externalTSA *externalTSA
+	//externalWorkerKeys public keys of workers registered through bindings
+	externalWorkerKeys []string
+	//localUsers additional users of the main team, nil keeps those of the previous manifest
+	localUsers []LocalUser
+}
+
+//GenerateManifest Generate a bosh manifest. Cloud Controller will pass in the arguments.
+//The plan, the parameters and the jobs of the releases are validated before anything is provisioned.
+//Every failure is returned the same way, the broker shows its message to the user, and is logged for the operator
 func (m ManifestGenerator) GenerateManifest(
 	serviceDeployment serviceadapter.ServiceDeployment,
 	plan serviceadapter.Plan,
@@ -71,7 +132,14 @@ func (m ManifestGenerator) GenerateManifest(
 	previousPlan *serviceadapter.Plan,
 ) (manifest bosh.BoshManifest, err error) {
 
+	defer func() {
+		if err != nil {
+			m.StderrLogger.Printf("generating the manifest of %s: %s", serviceDeployment.DeploymentName, err)
+		}
+	}()
+
 	stemcellAlias := "only-stemcell"
+	generation := detectGeneration(serviceDeployment.Releases)
 
 	instanceGroups := []bosh.InstanceGroup{}
 
@@ -83,16 +151,120 @@ func (m ManifestGenerator) GenerateManifest(
 		})
 	}
 
-	webPassword, _ := CurrentPasswordGenerator()
-	dbPassword, _ := CurrentPasswordGenerator()
+	plan, adapterConfig, err := resolvePlan(m.ConfigPath, m.StderrLogger, plan, requestParams.ArbitraryParams())
+	if err != nil {
+		return
+	}
+	config, problems := parsePlanConfig(generation, plan)
+	params, parameterProblems := parseInstanceParameters(generation, plan, config, requestParams.ArbitraryParams())
+	if problems = append(problems, parameterProblems...); len(problems) > 0 {
+		err = joinProblems(problems)
+		return
+	}
+	creds := newCredentials(serviceDeployment.DeploymentName, config.CredentialStorage, config.CredhubPathPrefix, previousManifest)
+	externalDatabase := config.ExternalDatabase
+	webInstanceGroup := config.Web
+	highlyAvailable := webInstanceGroup.Instances > 1
+	secrets, err := generateSecrets(generation, creds, serviceDeployment.DeploymentName, externalDatabase, previousManifest)
+	if err != nil {
+		return
+	}
+	dbGeneration := generation
+	if externalDatabase == nil {
+		dbGeneration = previousDBGeneration(previousManifest, generation)
+	}
+	if dbGeneration != generation {
+		if !releasesProvideJob(serviceDeployment.Releases, PostgresJobName) {
+			err = fmt.Errorf("the db instance group of %s runs the %s job of concourse 3, the %s job would start with an empty database. Keep a release providing the %s job in the service deployment", serviceDeployment.DeploymentName, PostgresJobName, PostgresServerJobName, PostgresJobName)
+			return
+		}
+		if config.DedicatedCredhub != nil && config.ExternalDatabase == nil {
+			err = fmt.Errorf("credhub: the db instance group of %s still runs the %s job of concourse 3, which cannot hold the credhub database", serviceDeployment.DeploymentName, PostgresJobName)
+			return
+		}
+		secrets.database.host = boshDNSAddress(DatabaseInstanceName, config.Database.Networks, serviceDeployment.DeploymentName)
+	}
+	endpoint := config.Ingress.endpoint(serviceDeployment.DeploymentName, webInstanceGroup.Networks, config.TLS != nil, previousManifest)
+	secrets.webCertificate, secrets.webCA, err = creds.webCertificate(config.TLS, endpoint.host, previousGeneration(previousManifest, generation))
+	if err != nil {
+		return
+	}
+	secrets.credhub, err = generateCredhubSecrets(config, creds, serviceDeployment.DeploymentName, externalDatabase, previousManifest)
+	if err != nil {
+		return
+	}
+	secrets.cfLogin, err = generateCFLogin(generation, config.UAA, serviceDeployment.DeploymentName, endpoint.externalURL, requestParams, previousManifest)
+	if err != nil {
+		return
+	}
+	secrets.localUsers = creds.localUserPasswords(params.localUsers)
+	pipeline := creds.bootstrapPipelineSecrets(params.pipeline)
+	if len(creds.stored) > 0 && (adapterConfig.Credhub == nil || m.SecretStore == nil) {
+		err = fmt.Errorf("secrets of the parameters of credhub plans are stored through director_credhub of the adapter config, it is not set")
+		return
+	}
+	if m.WorkerKeyStore != nil {
+		secrets.externalWorkerKeys, err = m.WorkerKeyStore.List(serviceDeployment.DeploymentName)
+		if err != nil {
+			return
+		}
+	}
+	jobs, err := gatherDeploymentJobs(serviceDeployment.Releases, generation, dbGeneration, config, endpoint, secrets.credhub)
+	if err != nil {
+		return
+	}
 
-	webInstanceGroup := findInstanceGroup(plan, WebInstanceName)
-	webProperties := m.webInstanceProperties(dbPassword, webPassword, serviceDeployment.DeploymentName, plan.Properties, requestParams.ArbitraryParams(), previousManifest)
-	webJobs, err := gatherJobs(serviceDeployment.Releases, AtcJobName, TsaJobName, RouteRegisterJobName)
-	webJobs[2].AddCrossDeploymentConsumesLink("nats", "nats", plan.Properties["cf_deployment"].(string))
+	//everything is validated, only now ports, databases, uaa clients and credhub variables are created
+	secrets.externalTSA, err = m.allocateExternalTSA(config.TSATCPRoute, serviceDeployment.DeploymentName, previousManifest)
 	if err != nil {
 		return
 	}
+	if previousGeneration(previousManifest, generation) != generation {
+		m.StderrLogger.Printf("migrating %s to the job layout of the supplied concourse release, database %s and role %s are carried over", serviceDeployment.DeploymentName, secrets.database.name, secrets.database.role)
+	}
+	if externalDatabase != nil {
+		previousHost, _ := previousStringProperty(previousManifest, WebInstanceName, "postgresql", "host")
+		previousDatabase, _ := previousStringProperty(previousManifest, WebInstanceName, "postgresql", "database")
+		if err = m.provisionDatabase(secrets.database, previousHost, previousDatabase); err != nil {
+			return
+		}
+	}
+	if externalDatabase != nil && secrets.credhub != nil {
+		previousHost, _ := previousCredhubProperty(previousManifest, "data_storage", "host")
+		previousDatabase, _ := previousCredhubProperty(previousManifest, "data_storage", "database")
+		if err = m.provisionDatabase(secrets.credhub.database, previousHost, previousDatabase); err != nil {
+			return
+		}
+	}
+	if secrets.cfLogin != nil && m.ClientRegistrar != nil {
+		err = m.ClientRegistrar.EnsureClient(*secrets.cfLogin.config, secrets.cfLogin.client)
+		if err != nil {
+			m.StderrLogger.Printf("registering uaa client %s: %s", secrets.cfLogin.client.ClientID, err)
+			return
+		}
+	}
+	for _, secret := range creds.stored {
+		err = m.SecretStore.Store(*adapterConfig.Credhub, secret.name, secret.variableType, secret.value)
+		if err != nil {
+			m.StderrLogger.Printf("storing %s: %s", secret.name, err)
+			return
+		}
+	}
+
+	webProperties := m.webInstanceProperties(generation, secrets, endpoint, serviceDeployment.DeploymentName, config, params.mainTeam, previousManifest)
+	webJobs := jobs.web
+	if endpoint.routed() {
+		nats := endpoint.route.NATS
+		findJob(webJobs, RouteRegisterJobName).AddCrossDeploymentConsumesLink(nats.Link, nats.From, nats.Deployment)
+		if secrets.externalTSA != nil {
+			findJob(webJobs, RouteRegisterJobName).AddCrossDeploymentConsumesLink("routing_api", "routing_api", endpoint.cfDeployment)
+		}
+	}
+	workerGatewayAddress := ""
+	if highlyAvailable {
+		generation.linkWebJobs(webJobs)
+		workerGatewayAddress = boshDNSAddress(WebInstanceName, webInstanceGroup.Networks, serviceDeployment.DeploymentName)
+	}
 	instanceGroups = append(instanceGroups, bosh.InstanceGroup{
 		Name:         WebInstanceName,
 		Instances:    webInstanceGroup.Instances,
@@ -105,42 +277,68 @@ func (m ManifestGenerator) GenerateManifest(
 		Properties:   webProperties,
 	})
 
-	dbInstanceGroup := findInstanceGroup(plan, DatabaseInstanceName)
-	dbProperties := m.dbInstanceProperties(dbPassword, serviceDeployment.DeploymentName, plan.Properties, requestParams.ArbitraryParams(), previousManifest)
-	dbJobs, err := gatherJobs(serviceDeployment.Releases, PostgresJobName)
-	if err != nil {
-		return
+	if externalDatabase == nil {
+		dbInstanceGroup := config.Database
+		dbProperties := m.dbInstanceProperties(dbGeneration, secrets, serviceDeployment.DeploymentName, plan.Properties, requestParams.ArbitraryParams(), previousManifest)
+		if secrets.credhub != nil && secrets.credhub.colocated {
+			dbProperties["credhub"] = credhubJobProperties(secrets.credhub)
+		}
+		instanceGroups = append(instanceGroups, bosh.InstanceGroup{
+			Name:               DatabaseInstanceName,
+			Instances:          dbInstanceGroup.Instances,
+			Jobs:               jobs.db,
+			VMType:             dbInstanceGroup.VMType,
+			VMExtensions:       dbInstanceGroup.VMExtensions,
+			PersistentDiskType: dbInstanceGroup.PersistentDiskType,
+			Stemcell:           stemcellAlias,
+			Networks:           mapNetworksToBoshNetworks(dbInstanceGroup.Networks),
+			AZs:                dbInstanceGroup.AZs,
+			Properties:         dbProperties,
+		})
 	}
-	instanceGroups = append(instanceGroups, bosh.InstanceGroup{
-		Name:               DatabaseInstanceName,
-		Instances:          dbInstanceGroup.Instances,
-		Jobs:               dbJobs,
-		VMType:             dbInstanceGroup.VMType,
-		VMExtensions:       dbInstanceGroup.VMExtensions,
-		PersistentDiskType: dbInstanceGroup.PersistentDiskType,
-		Stemcell:           stemcellAlias,
-		Networks:           mapNetworksToBoshNetworks(dbInstanceGroup.Networks),
-		AZs:                dbInstanceGroup.AZs,
-		Properties:         dbProperties,
-	})
 
-	workerInstanceGroup := findInstanceGroup(plan, WorkerInstanceName)
-	workerProperties := m.workerInstanceProperties(serviceDeployment.DeploymentName, plan.Properties, requestParams.ArbitraryParams(), previousManifest)
-	workerJobs, err := gatherJobs(serviceDeployment.Releases, GroundCrewJobName, BaggageClaimJobName, GardenJobName)
+	if secrets.credhub != nil && !secrets.credhub.colocated {
+		credhubInstanceGroup := config.Credhub
+		instanceGroups = append(instanceGroups, bosh.InstanceGroup{
+			Name:               CredhubInstanceName,
+			Instances:          credhubInstanceGroup.Instances,
+			Jobs:               jobs.credhub,
+			VMType:             credhubInstanceGroup.VMType,
+			VMExtensions:       credhubInstanceGroup.VMExtensions,
+			PersistentDiskType: credhubInstanceGroup.PersistentDiskType,
+			Stemcell:           stemcellAlias,
+			Networks:           mapNetworksToBoshNetworks(credhubInstanceGroup.Networks),
+			AZs:                credhubInstanceGroup.AZs,
+			Properties:         map[string]interface{}{"credhub": credhubJobProperties(secrets.credhub)},
+		})
+	}
+
+	for i, workerInstanceGroup := range config.Workers {
+		workerProperties := m.workerInstanceProperties(generation, secrets, config.WorkerPools[workerInstanceGroup.Name], workerGatewayAddress, serviceDeployment.DeploymentName, plan.Properties, requestParams.ArbitraryParams(), previousManifest)
+		instanceGroups = append(instanceGroups, bosh.InstanceGroup{
+			Name:         workerInstanceGroup.Name,
+			Instances:    workerInstanceGroup.Instances,
+			Jobs:         jobs.workers[i],
+			VMType:       workerInstanceGroup.VMType,
+			VMExtensions: workerInstanceGroup.VMExtensions,
+			Stemcell:     stemcellAlias,
+			Networks:     mapNetworksToBoshNetworks(workerInstanceGroup.Networks),
+			AZs:          workerInstanceGroup.AZs,
+			Properties:   workerProperties,
+		})
+	}
+
+	if teamsInstanceGroup := config.TeamsErrand; teamsInstanceGroup != nil {
+		instanceGroups = append(instanceGroups, errandInstanceGroup(teamsInstanceGroup, jobs.teams, stemcellAlias, m.teamsErrandProperties(params.teams, secrets, endpoint.externalURL, previousManifest)))
+	}
+	if bootstrapInstanceGroup := config.BootstrapErrand; bootstrapInstanceGroup != nil {
+		instanceGroups = append(instanceGroups, errandInstanceGroup(bootstrapInstanceGroup, jobs.bootstrap, stemcellAlias, bootstrapErrandProperties(pipeline, secrets, endpoint.externalURL, previousManifest)))
+	}
+
+	err = addSyslogForwarder(config.Syslog, serviceDeployment.Releases, instanceGroups)
 	if err != nil {
 		return
 	}
-	instanceGroups = append(instanceGroups, bosh.InstanceGroup{
-		Name:         WorkerInstanceName,
-		Instances:    workerInstanceGroup.Instances,
-		Jobs:         workerJobs,
-		VMType:       workerInstanceGroup.VMType,
-		VMExtensions: workerInstanceGroup.VMExtensions,
-		Stemcell:     stemcellAlias,
-		Networks:     mapNetworksToBoshNetworks(workerInstanceGroup.Networks),
-		AZs:          workerInstanceGroup.AZs,
-		Properties:   workerProperties,
-	})
 
 	return bosh.BoshManifest{
 		Name: serviceDeployment.DeploymentName,
@@ -154,9 +352,85 @@ func (m ManifestGenerator) GenerateManifest(
 		Releases:       releases,
 		InstanceGroups: instanceGroups,
 		Update:         generateUpdateBlock(plan.Update, previousManifest),
+		Variables:      creds.variables,
 	}, nil
 }
 
+//provisionDatabase creates the database of an instance on the external database unless the previous manifest already uses it
+func (m ManifestGenerator) provisionDatabase(database concourseDatabase, previousHost string, previousDatabase string) error {
+	if m.DatabaseProvisioner == nil || databaseProvisioned(database, previousHost, previousDatabase) {
+		return nil
+	}
+	m.StderrLogger.Printf("provisioning database %s on %s, drop it and its role once the instance is deleted", database.name, database.external.Host)
+	err := m.DatabaseProvisioner.EnsureDatabase(*database.external, database.name, database.role, database.password)
+	if err != nil {
+		m.StderrLogger.Printf("provisioning database %s on %s: %s", database.name, database.external.Host, err)
+	}
+	return err
+}
+
+//generateSecrets worker gateway keys and the token signing key shared by all web nodes are generated once.
+//Secrets are read back with the property names of the previous manifest, so deployments of
+//concourse 3 keep their credentials and database when they move to the concourse 4 layout
+func generateSecrets(generation concourseGeneration, creds *credentials, deploymentName string, externalDatabase *ExternalDatabase, previousManifest *bosh.BoshManifest) (secrets concourseSecrets, err error) {
+	previous := previousGeneration(previousManifest, generation)
+	previousAdminPassword, found := previous.previousAdminPassword(previousManifest)
+	secrets.adminPassword, err = creds.passwordWithPrevious("basic_auth_password", previousAdminPassword, found)
+	if err != nil {
+		return
+	}
+	if externalDatabase != nil {
+		secrets.database, err = generateExternalDatabase(deploymentName, externalDatabase, previousManifest)
+	} else {
+		secrets.database, err = generateInternalDatabase(previousDBGeneration(previousManifest, generation), creds, previousManifest)
+	}
+	if err != nil {
+		return
+	}
+	if previous == concourse3 {
+		secrets.tsaHostKey, err = creds.sshKeyFromPrivateKey("tsa_host_key", WebInstanceName, "tsa", "host_key")
+	} else {
+		secrets.tsaHostKey, err = creds.sshKey("tsa_host_key", WebInstanceName, "worker_gateway", "host_key")
+	}
+	if err != nil {
+		return
+	}
+	if previous == concourse3 {
+		secrets.workerKey, err = creds.sshKeyFromPrivateKey("worker_key", previousWorkerPool(previousManifest), "tsa", "worker_key")
+	} else {
+		secrets.workerKey, err = creds.sshKey("worker_key", previousWorkerPool(previousManifest), "worker_gateway", "worker_key")
+	}
+	if err != nil {
+		return
+	}
+	secrets.tokenSigningKey, err = creds.rsaKey("token_signing_key", WebInstanceName, "token_signing_key")
+	return
+}
+
+func generateInternalDatabase(previous concourseGeneration, creds *credentials, previousManifest *bosh.BoshManifest) (database concourseDatabase, err error) {
+	database.password, err = creds.password("atc_db_password", DatabaseInstanceName, previous.dbPasswordPath()...)
+	if err != nil {
+		return
+	}
+	database.name, database.role = "atc_db", "atc"
+	if name, role, found := previous.previousDatabase(previousManifest); found {
+		database.name, database.role = name, role
+	}
+	return
+}
+
+//generateExternalDatabase the role password is always a literal, the adapter has to know it to create the role
+func generateExternalDatabase(deploymentName string, externalDatabase *ExternalDatabase, previousManifest *bosh.BoshManifest) (database concourseDatabase, err error) {
+	name := externalDatabaseName(deploymentName)
+	database = concourseDatabase{name: name, role: name, external: externalDatabase}
+	if password, found := previousStringProperty(previousManifest, WebInstanceName, "postgresql", "role", "password"); found {
+		database.password = password
+		return
+	}
+	database.password, err = CurrentPasswordGenerator()
+	return
+}
+
 func findInstanceGroup(plan serviceadapter.Plan, instanceGroupName string) *serviceadapter.InstanceGroup {
 	for _, instanceGroup := range plan.InstanceGroups {
 		if instanceGroup.Name == instanceGroupName {
@@ -166,6 +440,68 @@ func findInstanceGroup(plan serviceadapter.Plan, instanceGroupName string) *serv
 	return nil
 }
 
+//findJob pointer into jobs so links can be added in place
+func findJob(jobs []bosh.Job, jobName string) *bosh.Job {
+	for i := range jobs {
+		if jobs[i].Name == jobName {
+			return &jobs[i]
+		}
+	}
+	return nil
+}
+
+//deploymentJobs jobs of the instance groups, gathered before anything is provisioned so a missing release fails early
+type deploymentJobs struct {
+	web       []bosh.Job
+	db        []bosh.Job
+	credhub   []bosh.Job
+	workers   [][]bosh.Job
+	teams     []bosh.Job
+	bootstrap []bosh.Job
+}
+
+//gatherDeploymentJobs every job the manifest will contain, all missing jobs are reported at once
+func gatherDeploymentJobs(releases serviceadapter.ServiceReleases, generation concourseGeneration, dbGeneration concourseGeneration, config *PlanConfig, endpoint webEndpoint, credhub *credhubSecrets) (deploymentJobs, error) {
+	problems := []string{}
+	gather := func(jobNames ...string) []bosh.Job {
+		jobs, err := gatherJobs(releases, jobNames...)
+		if err != nil && !containsString(problems, err.Error()) {
+			problems = append(problems, err.Error())
+		}
+		return jobs
+	}
+
+	jobs := deploymentJobs{}
+	webJobNames := generation.webJobs()
+	if !endpoint.routed() {
+		webJobNames = withoutJob(webJobNames, RouteRegisterJobName)
+	}
+	jobs.web = gather(webJobNames...)
+	if config.ExternalDatabase == nil {
+		dbJobNames := dbGeneration.dbJobs()
+		if credhub != nil && credhub.colocated {
+			dbJobNames = append(dbJobNames, CredhubJobName)
+		}
+		jobs.db = gather(dbJobNames...)
+	}
+	if credhub != nil && !credhub.colocated {
+		jobs.credhub = gather(CredhubJobName)
+	}
+	for range config.Workers {
+		jobs.workers = append(jobs.workers, gather(generation.workerJobs()...))
+	}
+	if config.TeamsErrand != nil {
+		jobs.teams = gather(TeamsErrandJobName)
+	}
+	if config.BootstrapErrand != nil {
+		jobs.bootstrap = gather(SetPipelineJobName)
+	}
+	if config.Syslog != nil {
+		gather(SyslogForwarderJobName)
+	}
+	return jobs, joinProblems(problems)
+}
+
 func gatherJobs(releases serviceadapter.ServiceReleases, jobNames ...string) ([]bosh.Job, error) {
 	jobs := []bosh.Job{}
 	for _, job := range jobNames {
@@ -205,48 +541,241 @@ func findReleaseForJob(requiredJob string, releases serviceadapter.ServiceReleas
 	return releasesThatProvideRequiredJob[0], nil
 }
 
-func (m ManifestGenerator) webInstanceProperties(dbPassword string, webPassword string, deploymentName string, planProperties serviceadapter.Properties, arbitraryParams map[string]interface{}, previousManifest *bosh.BoshManifest) map[string]interface{} {
-	appDomain := planProperties["app_domain"]
-	return map[string]interface{}{
-		"external_url":        fmt.Sprintf("https://%s.%s", deploymentName, appDomain),
-		"basic_auth_username": "atc",
-		"basic_auth_password": webPassword,
-		"postgresql_database": "atc_db",
-		"route_registrar": map[string]interface{}{
-			"routes": []map[string]interface{}{{
-				"name":                  "concourse-service",
-				"port":                  8080,
-				"registration_interval": "20s",
-				"uris":                  []string{fmt.Sprintf("%s.%s", deploymentName, appDomain)},
-			},
-			},
+//webInstanceProperties the main team members of the login providers offered by the plan are taken from the main_team parameter,
+//additional local users from the local_users parameter
+func (m ManifestGenerator) webInstanceProperties(generation concourseGeneration, secrets concourseSecrets, endpoint webEndpoint, deploymentName string, config *PlanConfig, mainTeam *MainTeamAuth, previousManifest *bosh.BoshManifest) map[string]interface{} {
+	auth := config.Auth
+	credentialManager := config.CredentialManager
+	properties := map[string]interface{}{
+		"external_url": endpoint.externalURL,
+	}
+	if endpoint.routed() {
+		routeRegistrar := endpoint.route.routeRegistrarProperties(endpoint.host, endpoint.uris, secrets.webCertificate != nil)
+		properties["route_registrar"] = routeRegistrar
+		if endpoint.route.Port != AtcPort {
+			properties["bind_port"] = endpoint.route.Port
+		}
+		if external := secrets.externalTSA; external != nil {
+			tcpRoute, routingAPI := tsaTCPRouteProperties(external, endpoint.route.RegistrationInterval)
+			routeRegistrar["routes"] = append(routeRegistrar["routes"].([]map[string]interface{}), tcpRoute)
+			routeRegistrar["routing_api"] = routingAPI
+			properties["external_tsa"] = map[string]interface{}{
+				"host": external.host,
+				"port": external.port,
+			}
+		}
+	}
+	if secrets.webCA != nil {
+		//tls_ca is read by the adapter only, renewals and bindings take the CA from it
+		properties["tls_ca"] = secrets.webCA
+	}
+	if credentialManager != nil {
+		key, managerProperties := credentialManagerProperties(credentialManager, deploymentName)
+		properties[key] = managerProperties
+	}
+	if secrets.credhub != nil {
+		properties["credhub"] = atcCredhubProperties(secrets.credhub)
+	}
+	authorizedKeys := []interface{}{secrets.workerKey["public_key"]}
+	for _, key := range secrets.externalWorkerKeys {
+		authorizedKeys = append(authorizedKeys, key)
+	}
+	properties["token_signing_key"] = secrets.tokenSigningKey
+
+	if generation == concourse3 {
+		properties["tsa"] = map[string]interface{}{
+			"host_key":        secrets.tsaHostKey["private_key"],
+			"host_public_key": secrets.tsaHostKey["public_key"],
+			"authorized_keys": authorizedKeys,
+		}
+		properties["basic_auth_username"] = AdminUsername
+		properties["basic_auth_password"] = secrets.adminPassword
+		if secrets.webCertificate != nil {
+			properties["tls_bind_port"] = AtcTLSPort
+			properties["tls_cert"] = secrets.webCertificate["certificate"]
+			properties["tls_key"] = secrets.webCertificate["private_key"]
+		}
+		if login := secrets.cfLogin; login != nil {
+			uaaAuth := map[string]interface{}{
+				"client_id":     login.client.ClientID,
+				"client_secret": login.clientSecret,
+				"auth_url":      login.config.URL + "/oauth/authorize",
+				"token_url":     login.config.URL + "/oauth/token",
+				"cf_url":        login.config.CFAPIURL,
+				"cf_spaces":     []string{login.spaceGUID},
+			}
+			if login.config.CACert != "" {
+				uaaAuth["cf_ca_cert"] = login.config.CACert
+			}
+			properties["uaa_auth"] = uaaAuth
+		}
+		if secrets.database.external != nil {
+			properties["postgresql"] = externalDatabaseProperties(secrets.database, secrets.database.external.CACert)
+			return properties
+		}
+		properties["postgresql_database"] = secrets.database.name
+		return properties
+	}
+
+	userEntries, usernames := localUserEntries(secrets.adminPassword, secrets.localUsers, previousManifest)
+	properties["add_local_users"] = userEntries
+	mainTeamAuth := map[string]interface{}{
+		"local": map[string]interface{}{
+			"users": usernames,
 		},
 	}
+	properties["main_team"] = map[string]interface{}{
+		"auth": mainTeamAuth,
+	}
+	if login := secrets.cfLogin; login != nil {
+		cf := map[string]interface{}{
+			"client_id":           login.client.ClientID,
+			"client_secret":       login.clientSecret,
+			"api_url":             login.config.CFAPIURL,
+			"skip_ssl_validation": login.config.SkipSSLValidation,
+		}
+		if login.config.CACert != "" {
+			cf["ca_cert"] = map[string]interface{}{"certificate": login.config.CACert}
+		}
+		properties["cf_auth"] = cf
+		mainTeamAuth["cf"] = map[string]interface{}{
+			"space_guids": []string{login.spaceGUID},
+		}
+	}
+	connectors, connectorMembers := authProperties(auth, mainTeam, previousManifest)
+	for key, value := range connectors {
+		properties[key] = value
+	}
+	for key, value := range connectorMembers {
+		mainTeamAuth[key] = value
+	}
+	postgresql := map[string]interface{}{
+		"database": secrets.database.name,
+		"role": map[string]interface{}{
+			"name":     secrets.database.role,
+			"password": secrets.database.password,
+		},
+	}
+	if secrets.database.host != "" {
+		postgresql["host"] = secrets.database.host
+		postgresql["port"] = 5432
+	}
+	properties["postgresql"] = postgresql
+	if secrets.database.external != nil {
+		properties["postgresql"] = externalDatabaseProperties(secrets.database, map[string]interface{}{
+			"certificate": secrets.database.external.CACert,
+		})
+	}
+	properties["worker_gateway"] = map[string]interface{}{
+		"host_key":        secrets.tsaHostKey,
+		"authorized_keys": authorizedKeys,
+	}
+	if secrets.webCertificate != nil {
+		properties["tls"] = map[string]interface{}{
+			"bind_port": AtcTLSPort,
+			"cert":      secrets.webCertificate,
+		}
+	}
+	return properties
 }
 
-func generateDatabase(dbPassword string) map[interface{}]interface{} {
+//externalDatabaseProperties atc settings of an external database, the shape of ca_cert differs between generations
+func externalDatabaseProperties(database concourseDatabase, caCert interface{}) map[string]interface{} {
+	properties := map[string]interface{}{
+		"host":     database.external.Host,
+		"port":     database.external.Port,
+		"database": database.name,
+		"role": map[string]interface{}{
+			"name":     database.role,
+			"password": database.password,
+		},
+		"sslmode": "disable",
+	}
+	if database.external.CACert != "" {
+		properties["sslmode"] = "verify-full"
+		properties["ca_cert"] = caCert
+	}
+	return properties
+}
+
+func generateDatabase(secrets concourseSecrets) map[interface{}]interface{} {
 	return map[interface{}]interface{}{
-		"name":     "atc_db",
-		"role":     "atc",
-		"password": dbPassword,
+		"name":     secrets.database.name,
+		"role":     secrets.database.role,
+		"password": secrets.database.password,
 	}
 }
 
-func (m ManifestGenerator) dbInstanceProperties(dbPassword string, deploymentName string, planProperties serviceadapter.Properties, arbitraryParams map[string]interface{}, previousManifest *bosh.BoshManifest) map[string]interface{} {
+func (m ManifestGenerator) dbInstanceProperties(generation concourseGeneration, secrets concourseSecrets, deploymentName string, planProperties serviceadapter.Properties, arbitraryParams map[string]interface{}, previousManifest *bosh.BoshManifest) map[string]interface{} {
+	if generation == concourse3 {
+		return map[string]interface{}{
+			"databases": []map[interface{}]interface{}{
+				generateDatabase(secrets),
+			},
+		}
+	}
+	databases := []map[string]interface{}{
+		{"name": secrets.database.name},
+	}
+	roles := []map[string]interface{}{
+		{"name": secrets.database.role, "password": secrets.database.password},
+	}
+	if secrets.credhub != nil && secrets.credhub.database.external == nil {
+		databases = append(databases, map[string]interface{}{"name": secrets.credhub.database.name})
+		roles = append(roles, map[string]interface{}{"name": secrets.credhub.database.role, "password": secrets.credhub.database.password})
+	}
 	return map[string]interface{}{
-		"databases": []map[interface{}]interface{}{
-			generateDatabase(dbPassword),
+		"databases": map[string]interface{}{
+			"port":      5432,
+			"databases": databases,
+			"roles":     roles,
 		},
 	}
 }
 
-func (m ManifestGenerator) workerInstanceProperties(deploymentName string, planProperties serviceadapter.Properties, arbitraryParams map[string]interface{}, previousManifest *bosh.BoshManifest) map[string]interface{} {
-	return map[string]interface{}{
-		"garden": map[interface{}]interface{}{
+//workerInstanceProperties workers of a highly available web tier register through the given address, resolving to every web node
+func (m ManifestGenerator) workerInstanceProperties(generation concourseGeneration, secrets concourseSecrets, pool WorkerPool, workerGatewayAddress string, deploymentName string, planProperties serviceadapter.Properties, arbitraryParams map[string]interface{}, previousManifest *bosh.BoshManifest) map[string]interface{} {
+	properties := map[string]interface{}{}
+	if len(pool.Tags) > 0 {
+		properties["tags"] = pool.Tags
+	}
+	if pool.Team != "" {
+		properties["team"] = pool.Team
+	}
+
+	if generation == concourse3 {
+		properties["garden"] = map[interface{}]interface{}{
 			"listen_network": "tcp",
 			"listen_address": "0.0.0.0:7777",
-		},
+		}
+		tsa := map[string]interface{}{
+			"host_public_key": secrets.tsaHostKey["public_key"],
+			"worker_key":      secrets.workerKey["private_key"],
+		}
+		if workerGatewayAddress != "" {
+			tsa["host"] = workerGatewayAddress
+		}
+		properties["tsa"] = tsa
+		return properties
+	}
+	workerGateway := map[string]interface{}{
+		"worker_key":      secrets.workerKey,
+		"host_public_key": secrets.tsaHostKey["public_key"],
+	}
+	if workerGatewayAddress != "" {
+		workerGateway["hosts"] = []string{fmt.Sprintf("%s:%d", workerGatewayAddress, TsaPort)}
+	}
+	properties["worker_gateway"] = workerGateway
+	return properties
+}
+
+//boshDNSAddress bosh dns query resolving to all healthy instances of the instance group
+func boshDNSAddress(instanceGroupName string, networks []string, deploymentName string) string {
+	network := "default"
+	if len(networks) > 0 {
+		network = networks[0]
 	}
+	return strings.Replace(fmt.Sprintf("q-s0.%s.%s.%s.bosh", instanceGroupName, network, deploymentName), "_", "-", -1)
 }
 
 func generateUpdateBlock(update *serviceadapter.Update, previousManifest *bosh.BoshManifest) bosh.Update {
@@ -1,9 +1,19 @@
 package adapter_test
 
 import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
+	"math/big"
 	"strings"
+	"time"
 
 	"github.com/datianshi/concourse-service-adapter/adapter"
 	"github.com/pivotal-cf/on-demand-services-sdk/bosh"
@@ -15,6 +25,7 @@ import (
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/onsi/gomega/gbytes"
+	yaml "gopkg.in/yaml.v2"
 )
 
 var _ = Describe("Concourse Service Adapter", func() {
@@ -29,6 +40,7 @@ var _ = Describe("Concourse Service Adapter", func() {
 		concoursePlan            serviceadapter.Plan
 		stderr                   *gbytes.Buffer
 		stderrLogger             *log.Logger
+		originalKeyPairGenerator = adapter.CurrentKeyPairGenerator
 	)
 
 	BeforeEach(func() {
@@ -63,9 +75,7 @@ var _ = Describe("Concourse Service Adapter", func() {
 		}
 
 		defaultRequestParameters = map[string]interface{}{
-			"parameters": map[string]interface{}{
-				"app_domain": "systemdomain.com",
-			},
+			"parameters": map[string]interface{}{},
 		}
 
 		defaultServiceReleases = serviceadapter.ServiceReleases{
@@ -102,6 +112,12 @@ var _ = Describe("Concourse Service Adapter", func() {
 		manifestGenerator = createManifestGenerator("concourse-service-adapter.conf", stderrLogger)
 
 		binder = adapter.Binder{StderrLogger: stderrLogger}
+
+		adapter.CurrentKeyPairGenerator = pooledKeyPairGenerator(originalKeyPairGenerator)
+	})
+
+	AfterEach(func() {
+		adapter.CurrentKeyPairGenerator = originalKeyPairGenerator
 	})
 
 	Describe("Generating manifests", func() {
@@ -236,75 +252,2633 @@ var _ = Describe("Concourse Service Adapter", func() {
 
 		})
 
-	})
+		Context("generated credentials", func() {
+			var originalPasswordGenerator func() (string, error)
 
-	Describe("binding", func() {
-		var (
-			actualBinding    serviceadapter.Binding
-			actualBindingErr error
-			boshVMs          bosh.BoshVMs
-			currentManifest  bosh.BoshManifest
-		)
-		BeforeEach(func() {
+			BeforeEach(func() {
+				originalPasswordGenerator = adapter.CurrentPasswordGenerator
+				adapter.CurrentPasswordGenerator = func() (string, error) {
+					return "generated-password", nil
+				}
+			})
 
-			boshVMs = bosh.BoshVMs{}
-			currentManifest = bosh.BoshManifest{
-				InstanceGroups: []bosh.InstanceGroup{
+			AfterEach(func() {
+				adapter.CurrentPasswordGenerator = originalPasswordGenerator
+			})
+
+			It("generates new passwords for a fresh deployment", func() {
+				generated, generateErr := generateManifest(
+					manifestGenerator,
+					defaultServiceReleases,
+					concoursePlan,
+					defaultRequestParameters,
+					nil,
+					nil,
+				)
+
+				Expect(generateErr).NotTo(HaveOccurred())
+				Expect(generated.InstanceGroups[0].Properties["basic_auth_password"]).To(Equal("generated-password"))
+				dbPassword := generated.InstanceGroups[1].Properties["databases"].([]map[interface{}]interface{})[0]["password"]
+				Expect(dbPassword).To(Equal("generated-password"))
+			})
+
+			It("reuses the passwords of the previous manifest", func() {
+				oldManifest := createDefaultOldManifest()
+				oldManifest.InstanceGroups = []bosh.InstanceGroup{
 					{
+						Name: adapter.WebInstanceName,
 						Properties: map[string]interface{}{
-							"basic_auth_username": "atc",
-							"basic_auth_password": "password",
-							"external_url":        "host",
+							"basic_auth_password": "previous-web-password",
 						},
 					},
-				},
-			}
+					{
+						Name: adapter.DatabaseInstanceName,
+						Properties: map[string]interface{}{
+							"databases": []interface{}{
+								map[interface{}]interface{}{
+									"name":     "atc_db",
+									"role":     "atc",
+									"password": "previous-db-password",
+								},
+							},
+						},
+					},
+				}
+
+				generated, generateErr := generateManifest(
+					manifestGenerator,
+					defaultServiceReleases,
+					concoursePlan,
+					defaultRequestParameters,
+					&oldManifest,
+					nil,
+				)
 
+				Expect(generateErr).NotTo(HaveOccurred())
+				Expect(generated.InstanceGroups[0].Properties["basic_auth_password"]).To(Equal("previous-web-password"))
+				dbPassword := generated.InstanceGroups[1].Properties["databases"].([]map[interface{}]interface{})[0]["password"]
+				Expect(dbPassword).To(Equal("previous-db-password"))
+			})
+
+			It("produces the same credentials when regenerating from its own manifest", func() {
+				adapter.CurrentPasswordGenerator = originalPasswordGenerator
+				first, generateErr := generateManifest(
+					manifestGenerator,
+					defaultServiceReleases,
+					concoursePlan,
+					defaultRequestParameters,
+					nil,
+					nil,
+				)
+				Expect(generateErr).NotTo(HaveOccurred())
+
+				second, generateErr := generateManifest(
+					manifestGenerator,
+					defaultServiceReleases,
+					concoursePlan,
+					defaultRequestParameters,
+					&first,
+					nil,
+				)
+				Expect(generateErr).NotTo(HaveOccurred())
+				Expect(second.InstanceGroups).To(Equal(first.InstanceGroups))
+			})
 		})
 
-		JustBeforeEach(func() {
-			actualBinding, actualBindingErr = binder.CreateBinding("not-relevant", boshVMs, currentManifest, nil)
+		Context("concourse 4 and later releases", func() {
+			var (
+				concourse4Releases        serviceadapter.ServiceReleases
+				originalPasswordGenerator func() (string, error)
+				generatedKeyPairs         int
+			)
+
+			BeforeEach(func() {
+				concourse4Releases = serviceadapter.ServiceReleases{
+					{
+						Name:    adapter.ConcourseReleaseName,
+						Version: "5",
+						Jobs:    []string{adapter.WebJobName, adapter.WorkerJobName},
+					},
+					{
+						Name:    adapter.BpmReleaseName,
+						Version: "1",
+						Jobs:    []string{adapter.BpmJobName},
+					},
+					{
+						Name:    adapter.PostgresReleaseName,
+						Version: "38",
+						Jobs:    []string{adapter.PostgresServerJobName},
+					},
+					{
+						Name:    adapter.RoutingReleaseName,
+						Version: "9",
+						Jobs:    []string{adapter.RouteRegisterJobName},
+					},
+				}
+				generatedKeyPairs = 0
+				adapter.CurrentKeyPairGenerator = func() (adapter.KeyPair, error) {
+					generatedKeyPairs++
+					return adapter.KeyPair{
+						PrivateKey:   fmt.Sprintf("private-key-%d", generatedKeyPairs),
+						PublicKey:    fmt.Sprintf("public-key-%d", generatedKeyPairs),
+						SSHPublicKey: fmt.Sprintf("ssh-rsa key-%d", generatedKeyPairs),
+					}, nil
+				}
+				originalPasswordGenerator = adapter.CurrentPasswordGenerator
+				adapter.CurrentPasswordGenerator = func() (string, error) {
+					return "generated-password", nil
+				}
+			})
+
+			AfterEach(func() {
+				adapter.CurrentPasswordGenerator = originalPasswordGenerator
+			})
+
+			It("colocates web, bpm and route_registrar on the web instance group", func() {
+				generated, generateErr := generateManifest(manifestGenerator, concourse4Releases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).NotTo(HaveOccurred())
+				web := generated.InstanceGroups[0]
+				Expect(web.Name).To(Equal(adapter.WebInstanceName))
+				Expect(web.Jobs).To(HaveLen(3))
+				Expect(web.Jobs[0].Name).To(Equal(adapter.WebJobName))
+				Expect(web.Jobs[1].Name).To(Equal(adapter.BpmJobName))
+				Expect(web.Jobs[1].Release).To(Equal(adapter.BpmReleaseName))
+				Expect(web.Jobs[2].Name).To(Equal(adapter.RouteRegisterJobName))
+				Expect(web.Jobs[2].Consumes).To(HaveKey("nats"))
+			})
+
+			It("configures local users, postgres and keys on the web instance group", func() {
+				generated, generateErr := generateManifest(manifestGenerator, concourse4Releases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).NotTo(HaveOccurred())
+				properties := generated.InstanceGroups[0].Properties
+				Expect(properties).NotTo(HaveKey("basic_auth_username"))
+				Expect(properties["add_local_users"]).To(Equal([]string{"atc:generated-password"}))
+				Expect(properties["postgresql"]).To(Equal(map[string]interface{}{
+					"database": "atc_db",
+					"role": map[string]interface{}{
+						"name":     "atc",
+						"password": "generated-password",
+					},
+				}))
+				Expect(properties["token_signing_key"]).To(HaveKey("private_key"))
+				gateway := properties["worker_gateway"].(map[string]interface{})
+				Expect(gateway["host_key"]).To(HaveKeyWithValue("public_key", HavePrefix("ssh-rsa")))
+				Expect(gateway["authorized_keys"]).To(HaveLen(1))
+			})
+
+			It("uses the postgres release on the db instance group", func() {
+				generated, generateErr := generateManifest(manifestGenerator, concourse4Releases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).NotTo(HaveOccurred())
+				db := generated.InstanceGroups[1]
+				Expect(db.Jobs).To(HaveLen(1))
+				Expect(db.Jobs[0].Name).To(Equal(adapter.PostgresServerJobName))
+				Expect(db.Jobs[0].Release).To(Equal(adapter.PostgresReleaseName))
+				databases := db.Properties["databases"].(map[string]interface{})
+				Expect(databases["databases"]).To(Equal([]map[string]interface{}{{"name": "atc_db"}}))
+				Expect(databases["roles"]).To(Equal([]map[string]interface{}{{"name": "atc", "password": "generated-password"}}))
+			})
+
+			It("runs the single worker job authorized against the web tier", func() {
+				generated, generateErr := generateManifest(manifestGenerator, concourse4Releases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).NotTo(HaveOccurred())
+				worker := generated.InstanceGroups[2]
+				Expect(worker.Jobs).To(HaveLen(1))
+				Expect(worker.Jobs[0].Name).To(Equal(adapter.WorkerJobName))
+
+				webGateway := generated.InstanceGroups[0].Properties["worker_gateway"].(map[string]interface{})
+				workerGateway := worker.Properties["worker_gateway"].(map[string]interface{})
+				workerKey := workerGateway["worker_key"].(map[string]interface{})
+				Expect(webGateway["authorized_keys"]).To(ConsistOf(workerKey["public_key"]))
+				Expect(workerGateway["host_public_key"]).To(Equal(webGateway["host_key"].(map[string]interface{})["public_key"]))
+			})
+
+			It("keeps passwords and keys stable across updates", func() {
+				first, generateErr := generateManifest(manifestGenerator, concourse4Releases, concoursePlan, defaultRequestParameters, nil, nil)
+				Expect(generateErr).NotTo(HaveOccurred())
+
+				adapter.CurrentPasswordGenerator = func() (string, error) {
+					return "rotated-password", nil
+				}
+				second, generateErr := generateManifest(manifestGenerator, concourse4Releases, concoursePlan, defaultRequestParameters, &first, nil)
+				Expect(generateErr).NotTo(HaveOccurred())
+				Expect(generatedKeyPairs).To(Equal(3))
+				Expect(second.InstanceGroups).To(Equal(first.InstanceGroups))
+			})
+
+			Context("migrating a concourse 3 deployment", func() {
+				var previous bosh.BoshManifest
+
+				BeforeEach(func() {
+					var generateErr error
+					adapter.CurrentPasswordGenerator = func() (string, error) {
+						return "concourse-3-password", nil
+					}
+					previous, generateErr = generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, nil, nil)
+					Expect(generateErr).NotTo(HaveOccurred())
+					previous.InstanceGroups[1].Properties["databases"] = []interface{}{
+						map[interface{}]interface{}{
+							"name":     "legacy_db",
+							"role":     "legacy_role",
+							"password": "legacy-db-password",
+						},
+					}
+					adapter.CurrentPasswordGenerator = func() (string, error) {
+						return "generated-password", nil
+					}
+					concoursePlan.Properties["app_domain"] = "newdomain.com"
+					concourse4Releases = append(concourse4Releases, serviceadapter.ServiceRelease{
+						Name: "concourse-postgresql", Version: "3.14", Jobs: []string{adapter.PostgresJobName},
+					})
+				})
+
+				It("carries the admin password over into the local users", func() {
+					generated, generateErr := generateManifest(manifestGenerator, concourse4Releases, concoursePlan, defaultRequestParameters, &previous, nil)
+
+					Expect(generateErr).NotTo(HaveOccurred())
+					Expect(generated.InstanceGroups[0].Properties["add_local_users"]).To(Equal([]string{"atc:concourse-3-password"}))
+				})
+
+				It("carries the database, role and password over", func() {
+					generated, generateErr := generateManifest(manifestGenerator, concourse4Releases, concoursePlan, defaultRequestParameters, &previous, nil)
+
+					Expect(generateErr).NotTo(HaveOccurred())
+					Expect(generated.InstanceGroups[0].Properties["postgresql"]).To(Equal(map[string]interface{}{
+						"host":     "q-s0.db.default-network.some-instance-id.bosh",
+						"port":     5432,
+						"database": "legacy_db",
+						"role": map[string]interface{}{
+							"name":     "legacy_role",
+							"password": "legacy-db-password",
+						},
+					}))
+				})
+
+				It("keeps the postgresql job and its data on the db instance group", func() {
+					generated, generateErr := generateManifest(manifestGenerator, concourse4Releases, concoursePlan, defaultRequestParameters, &previous, nil)
+					Expect(generateErr).NotTo(HaveOccurred())
+					db := generated.InstanceGroups[1]
+					Expect(db.Jobs).To(HaveLen(1))
+					Expect(db.Jobs[0].Name).To(Equal(adapter.PostgresJobName))
+					Expect(db.Jobs[0].Release).To(Equal("concourse-postgresql"))
+					Expect(db.Properties["databases"]).To(Equal([]map[interface{}]interface{}{
+						{"name": "legacy_db", "role": "legacy_role", "password": "legacy-db-password"},
+					}))
+
+					adapter.CurrentPasswordGenerator = func() (string, error) {
+						return "rotated-password", nil
+					}
+					again, generateErr := generateManifest(manifestGenerator, concourse4Releases, concoursePlan, defaultRequestParameters, &generated, nil)
+					Expect(generateErr).NotTo(HaveOccurred())
+					Expect(again.InstanceGroups[1]).To(Equal(db))
+					Expect(again.InstanceGroups[0].Properties["postgresql"]).To(Equal(generated.InstanceGroups[0].Properties["postgresql"]))
+				})
+
+				It("refuses to replace the postgresql job by an empty database", func() {
+					_, generateErr := generateManifest(manifestGenerator, concourse4Releases[:len(concourse4Releases)-1], concoursePlan, defaultRequestParameters, &previous, nil)
+
+					Expect(generateErr).To(MatchError(ContainSubstring("would start with an empty database")))
+				})
+
+				It("keeps the external url and route", func() {
+					generated, generateErr := generateManifest(manifestGenerator, concourse4Releases, concoursePlan, defaultRequestParameters, &previous, nil)
+
+					Expect(generateErr).NotTo(HaveOccurred())
+					Expect(generated.InstanceGroups[0].Properties["external_url"]).To(Equal("https://some-instance-id.systemdomain.com"))
+					route := generated.InstanceGroups[0].Properties["route_registrar"].(map[string]interface{})["routes"].([]map[string]interface{})[0]
+					Expect(route["uris"]).To(Equal([]string{"some-instance-id.systemdomain.com"}))
+
+					again, generateErr := generateManifest(manifestGenerator, concourse4Releases, concoursePlan, defaultRequestParameters, &generated, nil)
+					Expect(generateErr).NotTo(HaveOccurred())
+					Expect(again.InstanceGroups[0].Properties["external_url"]).To(Equal("https://some-instance-id.systemdomain.com"))
+				})
+
+				It("logs the migration", func() {
+					_, generateErr := generateManifest(manifestGenerator, concourse4Releases, concoursePlan, defaultRequestParameters, &previous, nil)
+
+					Expect(generateErr).NotTo(HaveOccurred())
+					Expect(stderr).To(gbytes.Say("migrating some-instance-id"))
+				})
+			})
+
+			It("lets workers of a highly available web tier reach every node", func() {
+				concoursePlan.InstanceGroups[0].Instances = 2
+				generated, generateErr := generateManifest(manifestGenerator, concourse4Releases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).NotTo(HaveOccurred())
+				workerGateway := generated.InstanceGroups[2].Properties["worker_gateway"].(map[string]interface{})
+				Expect(workerGateway["hosts"]).To(Equal([]string{"q-s0.web.default-network.some-instance-id.bosh:2222"}))
+			})
+
+			Context("external workers", func() {
+				var (
+					keyStoreDir string
+					portDir     string
+					generated   bosh.BoshManifest
+					topology    bosh.BoshVMs
+					bindParams  serviceadapter.RequestParameters
+				)
+
+				BeforeEach(func() {
+					var err error
+					keyStoreDir, err = ioutil.TempDir("", "worker-keys")
+					Expect(err).NotTo(HaveOccurred())
+					keyStore := adapter.FileWorkerKeyStore{Dir: keyStoreDir}
+					manifestGenerator.WorkerKeyStore = keyStore
+					binder.WorkerKeyStore = keyStore
+					portDir, err = ioutil.TempDir("", "tcp-ports")
+					Expect(err).NotTo(HaveOccurred())
+					manifestGenerator.PortAllocator = adapter.FilePortAllocator{Dir: portDir}
+					concoursePlan.Properties["tsa_tcp_route"] = map[string]interface{}{
+						"router_group":  "default-tcp",
+						"domain":        "tcp.systemdomain.com",
+						"min_port":      1024,
+						"max_port":      1025,
+						"client_id":     "tcp_emitter",
+						"client_secret": "tcp-secret",
+					}
+
+					generated, err = generateManifest(manifestGenerator, concourse4Releases, concoursePlan, defaultRequestParameters, nil, nil)
+					Expect(err).NotTo(HaveOccurred())
+					topology = bosh.BoshVMs{"web": []string{"10.0.0.1", "10.0.0.2"}}
+					bindParams = serviceadapter.RequestParameters{
+						"parameters": map[string]interface{}{"external_worker": true},
+					}
+				})
+
+				AfterEach(func() {
+					os.RemoveAll(keyStoreDir)
+					os.RemoveAll(portDir)
+				})
+
+				It("hands out the tsa and a fresh worker key", func() {
+					binding, bindingErr := binder.CreateBinding("binding-id", topology, generated, bindParams)
+
+					Expect(bindingErr).NotTo(HaveOccurred())
+					hostKey := generated.InstanceGroups[0].Properties["worker_gateway"].(map[string]interface{})["host_key"].(map[string]interface{})
+					Expect(binding.Credentials["tsa_host"]).To(Equal("tcp.systemdomain.com"))
+					Expect(binding.Credentials["tsa_port"]).To(Equal(1024))
+					Expect(binding.Credentials["tsa_host_public_key"]).To(Equal(hostKey["public_key"]))
+					Expect(binding.Credentials["worker_private_key"]).To(Equal("private-key-4"))
+					Expect(binding.Credentials["worker_key_notice"]).To(ContainSubstring("cf update-service"))
+				})
+
+				It("authorizes the worker key on the next deploy", func() {
+					_, bindingErr := binder.CreateBinding("binding-id", topology, generated, bindParams)
+					Expect(bindingErr).NotTo(HaveOccurred())
+
+					updated, generateErr := generateManifest(manifestGenerator, concourse4Releases, concoursePlan, defaultRequestParameters, &generated, nil)
+					Expect(generateErr).NotTo(HaveOccurred())
+					authorizedKeys := updated.InstanceGroups[0].Properties["worker_gateway"].(map[string]interface{})["authorized_keys"]
+					Expect(authorizedKeys).To(ConsistOf("ssh-rsa key-2", "ssh-rsa key-4"))
+				})
+
+				It("revokes the worker key on the next deploy after unbinding", func() {
+					_, bindingErr := binder.CreateBinding("binding-id", topology, generated, bindParams)
+					Expect(bindingErr).NotTo(HaveOccurred())
+					Expect(binder.DeleteBinding("binding-id", topology, generated, nil)).To(Succeed())
+
+					updated, generateErr := generateManifest(manifestGenerator, concourse4Releases, concoursePlan, defaultRequestParameters, &generated, nil)
+					Expect(generateErr).NotTo(HaveOccurred())
+					authorizedKeys := updated.InstanceGroups[0].Properties["worker_gateway"].(map[string]interface{})["authorized_keys"]
+					Expect(authorizedKeys).To(ConsistOf("ssh-rsa key-2"))
+				})
+
+				It("does not register workers unless asked to", func() {
+					binding, bindingErr := binder.CreateBinding("binding-id", topology, generated, nil)
+
+					Expect(bindingErr).NotTo(HaveOccurred())
+					Expect(binding.Credentials).NotTo(HaveKey("worker_private_key"))
+					keys, err := binder.WorkerKeyStore.List(generated.Name)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(keys).To(BeEmpty())
+				})
+
+				It("rejects external workers of instances without a tsa_tcp_route", func() {
+					delete(concoursePlan.Properties, "tsa_tcp_route")
+					unrouted, generateErr := generateManifest(manifestGenerator, concourse4Releases, concoursePlan, defaultRequestParameters, nil, nil)
+					Expect(generateErr).NotTo(HaveOccurred())
+
+					_, bindingErr := binder.CreateBinding("binding-id", topology, unrouted, bindParams)
+
+					Expect(bindingErr).To(MatchError("external workers cannot reach the tsa of some-instance-id, its plan needs a tsa_tcp_route"))
+					keys, err := binder.WorkerKeyStore.List(unrouted.Name)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(keys).To(BeEmpty())
+				})
+
+				It("rejects a non boolean external_worker parameter", func() {
+					bindParams["parameters"] = map[string]interface{}{"external_worker": "yes"}
+					_, bindingErr := binder.CreateBinding("binding-id", topology, generated, bindParams)
+
+					Expect(bindingErr).To(MatchError("external_worker must be a boolean"))
+				})
+			})
+
+			It("hands out the local admin user in bindings", func() {
+				generated, generateErr := generateManifest(manifestGenerator, concourse4Releases, concoursePlan, defaultRequestParameters, nil, nil)
+				Expect(generateErr).NotTo(HaveOccurred())
+
+				binding, bindingErr := binder.CreateBinding("binding-id", bosh.BoshVMs{}, generated, nil)
+				Expect(bindingErr).NotTo(HaveOccurred())
+				Expect(binding.Credentials["username"]).To(Equal("atc"))
+				Expect(binding.Credentials["password"]).To(Equal("generated-password"))
+			})
 		})
 
-		Context("has a password in the manifest", func() {
-			It("has no error", func() {
-				Expect(actualBindingErr).NotTo(HaveOccurred())
+		Context("highly available web tier", func() {
+			BeforeEach(func() {
+				concoursePlan.InstanceGroups[0].Instances = 3
 			})
 
-			It("returns the username from the manifest", func() {
-				Expect(actualBinding.Credentials["username"]).To(Equal("atc"))
+			It("links every tsa to all atc nodes", func() {
+				generated, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).NotTo(HaveOccurred())
+				Expect(generated.InstanceGroups[0].Jobs[0].Provides).To(Equal(map[string]bosh.ProvidesLink{"atc": {As: "atc"}}))
+				Expect(generated.InstanceGroups[0].Jobs[1].Consumes).To(Equal(map[string]interface{}{"atc": bosh.ConsumesLink{From: "atc"}}))
 			})
 
-			It("returns the password from the manifest", func() {
-				Expect(actualBinding.Credentials["password"]).To(Equal("password"))
+			It("shares a token signing key between the atc nodes", func() {
+				generated, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).NotTo(HaveOccurred())
+				Expect(generated.InstanceGroups[0].Properties["token_signing_key"]).To(HaveKeyWithValue("private_key", ContainSubstring("RSA PRIVATE KEY")))
 			})
 
-			It("returns the host from the vms", func() {
-				Expect(actualBinding.Credentials["host"]).To(Equal("host"))
+			It("registers workers through the bosh dns address of all web nodes", func() {
+				generated, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).NotTo(HaveOccurred())
+				Expect(generated.InstanceGroups[2].Properties["tsa"]).To(HaveKeyWithValue("host", "q-s0.web.default-network.some-instance-id.bosh"))
+			})
+
+			It("keeps a single web node as is", func() {
+				concoursePlan.InstanceGroups[0].Instances = 1
+				generated, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).NotTo(HaveOccurred())
+				Expect(generated.InstanceGroups[0].Jobs[1].Consumes).To(BeEmpty())
+				Expect(generated.InstanceGroups[2].Properties["tsa"]).NotTo(HaveKey("host"))
 			})
 		})
-	})
 
-})
+		Context("tsa and session signing keys", func() {
+			It("wires the tsa host key and the worker key into web and workers", func() {
+				generated, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, nil, nil)
 
-func createManifestGenerator(filename string, logger *log.Logger) adapter.ManifestGenerator {
-	return adapter.ManifestGenerator{
-		StderrLogger: logger,
-		ConfigPath:   getFixturePath(filename),
-	}
-}
+				Expect(generateErr).NotTo(HaveOccurred())
+				webTsa := generated.InstanceGroups[0].Properties["tsa"].(map[string]interface{})
+				workerTsa := generated.InstanceGroups[2].Properties["tsa"].(map[string]interface{})
+				Expect(webTsa["host_key"]).To(ContainSubstring("BEGIN RSA PRIVATE KEY"))
+				Expect(webTsa["host_public_key"]).To(HavePrefix("ssh-rsa "))
+				Expect(workerTsa["host_public_key"]).To(Equal(webTsa["host_public_key"]))
+				Expect(workerTsa["worker_key"]).To(ContainSubstring("BEGIN RSA PRIVATE KEY"))
+				Expect(webTsa["authorized_keys"]).To(HaveLen(1))
+				Expect(webTsa["authorized_keys"].([]interface{})[0]).To(HavePrefix("ssh-rsa "))
+			})
 
-func createDefaultEmptyManifest() bosh.BoshManifest {
-	return bosh.BoshManifest{}
-}
+			It("sets a token signing key on the web tier", func() {
+				generated, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, nil, nil)
 
-func createDefaultOldManifest() bosh.BoshManifest {
-	return bosh.BoshManifest{
-		Releases: []bosh.Release{
-			{Name: "some-release-name", Version: "4"},
-		},
-		InstanceGroups: []bosh.InstanceGroup{},
-	}
+				Expect(generateErr).NotTo(HaveOccurred())
+				tokenSigningKey := generated.InstanceGroups[0].Properties["token_signing_key"].(map[string]interface{})
+				Expect(tokenSigningKey["private_key"]).To(ContainSubstring("BEGIN RSA PRIVATE KEY"))
+				Expect(tokenSigningKey["public_key"]).To(ContainSubstring("BEGIN PUBLIC KEY"))
+			})
+
+			It("reads the keys back from the previous manifest", func() {
+				first, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, nil, nil)
+				Expect(generateErr).NotTo(HaveOccurred())
+
+				adapter.CurrentKeyPairGenerator = func() (adapter.KeyPair, error) {
+					return adapter.KeyPair{}, errors.New("keys must not be regenerated")
+				}
+				second, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, &first, nil)
+
+				Expect(generateErr).NotTo(HaveOccurred())
+				Expect(second.InstanceGroups[0].Properties["tsa"]).To(Equal(first.InstanceGroups[0].Properties["tsa"]))
+				Expect(second.InstanceGroups[0].Properties["token_signing_key"]).To(Equal(first.InstanceGroups[0].Properties["token_signing_key"]))
+				Expect(second.InstanceGroups[2].Properties["tsa"]).To(Equal(first.InstanceGroups[2].Properties["tsa"]))
+			})
+
+			It("declares ssh and rsa variables when credentials are stored in credhub", func() {
+				concoursePlan.Properties["credential_storage"] = "credhub"
+				generated, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).NotTo(HaveOccurred())
+				Expect(generated.Variables).To(ContainElement(bosh.Variable{Name: "/concourse/some-instance-id/tsa_host_key", Type: "ssh"}))
+				Expect(generated.Variables).To(ContainElement(bosh.Variable{Name: "/concourse/some-instance-id/worker_key", Type: "ssh"}))
+				Expect(generated.Variables).To(ContainElement(bosh.Variable{Name: "/concourse/some-instance-id/token_signing_key", Type: "rsa"}))
+				webTsa := generated.InstanceGroups[0].Properties["tsa"].(map[string]interface{})
+				Expect(webTsa["host_key"]).To(Equal("((/concourse/some-instance-id/tsa_host_key.private_key))"))
+			})
+		})
+
+		Context("worker pools", func() {
+			BeforeEach(func() {
+				concoursePlan.InstanceGroups = append(concoursePlan.InstanceGroups,
+					serviceadapter.InstanceGroup{
+						Name:      "worker-large",
+						VMType:    "xlarge",
+						Networks:  []string{"default_network"},
+						Instances: 2,
+						AZs:       []string{"az1"},
+					},
+					serviceadapter.InstanceGroup{
+						Name:      "worker-gpu-tagged",
+						VMType:    "gpu",
+						Networks:  []string{"default_network"},
+						Instances: 1,
+						AZs:       []string{"az1"},
+					},
+				)
+				concoursePlan.Properties["worker_pools"] = map[string]interface{}{
+					"worker-gpu-tagged": map[string]interface{}{
+						"tags": []interface{}{"gpu"},
+						"team": "machine-learning",
+					},
+				}
+			})
+
+			It("generates an instance group per pool", func() {
+				generated, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).NotTo(HaveOccurred())
+				Expect(generated.InstanceGroups).To(HaveLen(5))
+				for i, name := range []string{"worker", "worker-large", "worker-gpu-tagged"} {
+					pool := generated.InstanceGroups[2+i]
+					Expect(pool.Name).To(Equal(name))
+					Expect(pool.Jobs).To(HaveLen(3))
+					Expect(pool.Jobs[0].Name).To(Equal(adapter.GroundCrewJobName))
+				}
+				Expect(generated.InstanceGroups[3].VMType).To(Equal("xlarge"))
+				Expect(generated.InstanceGroups[3].Instances).To(Equal(2))
+			})
+
+			It("tags and assigns the configured pools", func() {
+				generated, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).NotTo(HaveOccurred())
+				Expect(generated.InstanceGroups[2].Properties).NotTo(HaveKey("tags"))
+				Expect(generated.InstanceGroups[3].Properties).NotTo(HaveKey("team"))
+				Expect(generated.InstanceGroups[4].Properties["tags"]).To(Equal([]string{"gpu"}))
+				Expect(generated.InstanceGroups[4].Properties["team"]).To(Equal("machine-learning"))
+			})
+
+			It("rejects configuration of a pool the plan does not define", func() {
+				concoursePlan.Properties["worker_pools"] = map[string]interface{}{
+					"worker-small": map[string]interface{}{"tags": []interface{}{"small"}},
+				}
+				_, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).To(MatchError(ContainSubstring("worker_pools.worker-small")))
+			})
+
+			It("requires at least one worker instance group", func() {
+				concoursePlan.InstanceGroups = concoursePlan.InstanceGroups[:2]
+				delete(concoursePlan.Properties, "worker_pools")
+				_, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).To(MatchError("plan has no worker instance group"))
+			})
+		})
+
+		Context("external database", func() {
+			var provisioner *fakeDatabaseProvisioner
+
+			BeforeEach(func() {
+				provisioner = &fakeDatabaseProvisioner{}
+				manifestGenerator.DatabaseProvisioner = provisioner
+				concoursePlan.Properties["external_database"] = map[string]interface{}{
+					"host":           "postgres.example.com",
+					"ca_cert":        "some-ca",
+					"admin_username": "admin",
+					"admin_password": "admin-password",
+				}
+			})
+
+			It("leaves out the db instance group", func() {
+				generated, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).NotTo(HaveOccurred())
+				Expect(generated.InstanceGroups).To(HaveLen(2))
+				Expect(generated.InstanceGroups[0].Name).To(Equal(adapter.WebInstanceName))
+				Expect(generated.InstanceGroups[1].Name).To(Equal(adapter.WorkerInstanceName))
+			})
+
+			It("points atc at the external server with a database per instance", func() {
+				generated, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).NotTo(HaveOccurred())
+				properties := generated.InstanceGroups[0].Properties
+				Expect(properties).NotTo(HaveKey("postgresql_database"))
+				postgresql := properties["postgresql"].(map[string]interface{})
+				Expect(postgresql["host"]).To(Equal("postgres.example.com"))
+				Expect(postgresql["port"]).To(Equal(5432))
+				Expect(postgresql["database"]).To(Equal("concourse_some_instance_id"))
+				Expect(postgresql["sslmode"]).To(Equal("verify-full"))
+				Expect(postgresql["ca_cert"]).To(Equal("some-ca"))
+				Expect(postgresql["role"]).To(HaveKeyWithValue("name", "concourse_some_instance_id"))
+			})
+
+			It("provisions the database and role with the admin credentials", func() {
+				generated, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).NotTo(HaveOccurred())
+				Expect(provisioner.server.AdminUsername).To(Equal("admin"))
+				Expect(provisioner.server.AdminPassword).To(Equal("admin-password"))
+				Expect(provisioner.database).To(Equal("concourse_some_instance_id"))
+				Expect(provisioner.role).To(Equal("concourse_some_instance_id"))
+				role := generated.InstanceGroups[0].Properties["postgresql"].(map[string]interface{})["role"].(map[string]interface{})
+				Expect(provisioner.password).To(Equal(role["password"]))
+			})
+
+			It("keeps the role password across updates", func() {
+				first, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, nil, nil)
+				Expect(generateErr).NotTo(HaveOccurred())
+
+				second, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, &first, nil)
+				Expect(generateErr).NotTo(HaveOccurred())
+				Expect(second.InstanceGroups[0].Properties["postgresql"]).To(Equal(first.InstanceGroups[0].Properties["postgresql"]))
+			})
+
+			It("leaves the server alone on updates of provisioned instances", func() {
+				first, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, nil, nil)
+				Expect(generateErr).NotTo(HaveOccurred())
+				*provisioner = fakeDatabaseProvisioner{}
+
+				_, generateErr = generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, &first, nil)
+				Expect(generateErr).NotTo(HaveOccurred())
+				Expect(provisioner.database).To(BeEmpty())
+			})
+
+			It("provisions the database again when the instance moves to another server", func() {
+				first, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, nil, nil)
+				Expect(generateErr).NotTo(HaveOccurred())
+				*provisioner = fakeDatabaseProvisioner{}
+				concoursePlan.Properties["external_database"].(map[string]interface{})["host"] = "postgres2.example.com"
+
+				_, generateErr = generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, &first, nil)
+				Expect(generateErr).NotTo(HaveOccurred())
+				Expect(provisioner.server.Host).To(Equal("postgres2.example.com"))
+				Expect(provisioner.database).To(Equal("concourse_some_instance_id"))
+				Expect(stderr).To(gbytes.Say("drop it and its role once the instance is deleted"))
+			})
+
+			It("fails when provisioning fails", func() {
+				provisioner.err = errors.New("connection refused")
+				_, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).To(MatchError("connection refused"))
+			})
+
+			It("requires the admin credentials", func() {
+				delete(concoursePlan.Properties["external_database"].(map[string]interface{}), "admin_password")
+				_, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).To(MatchError(ContainSubstring("admin_password must be set")))
+			})
+		})
+
+		Context("credentials stored in credhub", func() {
+			BeforeEach(func() {
+				concoursePlan.Properties["credential_storage"] = "credhub"
+			})
+
+			It("declares password variables", func() {
+				generated, generateErr := generateManifest(
+					manifestGenerator,
+					defaultServiceReleases,
+					concoursePlan,
+					defaultRequestParameters,
+					nil,
+					nil,
+				)
+
+				Expect(generateErr).NotTo(HaveOccurred())
+				Expect(generated.Variables).To(ContainElement(bosh.Variable{Name: "/concourse/some-instance-id/basic_auth_password", Type: "password"}))
+				Expect(generated.Variables).To(ContainElement(bosh.Variable{Name: "/concourse/some-instance-id/atc_db_password", Type: "password"}))
+			})
+
+			It("references the variables instead of literal passwords", func() {
+				generated, generateErr := generateManifest(
+					manifestGenerator,
+					defaultServiceReleases,
+					concoursePlan,
+					defaultRequestParameters,
+					nil,
+					nil,
+				)
+
+				Expect(generateErr).NotTo(HaveOccurred())
+				Expect(generated.InstanceGroups[0].Properties["basic_auth_password"]).To(Equal("((/concourse/some-instance-id/basic_auth_password))"))
+				dbPassword := generated.InstanceGroups[1].Properties["databases"].([]map[interface{}]interface{})[0]["password"]
+				Expect(dbPassword).To(Equal("((/concourse/some-instance-id/atc_db_password))"))
+			})
+
+			It("uses the configured path prefix", func() {
+				concoursePlan.Properties["credhub_path_prefix"] = "/odb/concourse/"
+				generated, generateErr := generateManifest(
+					manifestGenerator,
+					defaultServiceReleases,
+					concoursePlan,
+					defaultRequestParameters,
+					nil,
+					nil,
+				)
+
+				Expect(generateErr).NotTo(HaveOccurred())
+				Expect(generated.InstanceGroups[0].Properties["basic_auth_password"]).To(Equal("((/odb/concourse/some-instance-id/basic_auth_password))"))
+			})
+
+			It("fails on an unknown credential storage", func() {
+				concoursePlan.Properties["credential_storage"] = "vault"
+				_, generateErr := generateManifest(
+					manifestGenerator,
+					defaultServiceReleases,
+					concoursePlan,
+					defaultRequestParameters,
+					nil,
+					nil,
+				)
+
+				Expect(generateErr).To(MatchError(ContainSubstring("unknown credential_storage")))
+			})
+		})
+
+		Context("tls", func() {
+			var caCertificate string
+
+			BeforeEach(func() {
+				var caKey string
+				caCertificate, caKey = testCertificateAuthority()
+				concoursePlan.Properties["tls"] = map[string]interface{}{
+					"enabled":        true,
+					"ca_certificate": caCertificate,
+					"ca_private_key": caKey,
+				}
+			})
+
+			It("serves atc over https with a certificate for the instance host", func() {
+				generated, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).NotTo(HaveOccurred())
+				properties := generated.InstanceGroups[0].Properties
+				Expect(properties["tls_bind_port"]).To(Equal(adapter.AtcTLSPort))
+				Expect(properties["tls_key"]).To(ContainSubstring("BEGIN RSA PRIVATE KEY"))
+				certificate := parseTestCertificate(properties["tls_cert"])
+				Expect(certificate.VerifyHostname("some-instance-id.systemdomain.com")).To(Succeed())
+				Expect(certificate.CheckSignatureFrom(parseTestCertificate(caCertificate))).To(Succeed())
+				Expect(properties["tls_ca"]).To(Equal(map[string]interface{}{"certificate": caCertificate}))
+			})
+
+			It("requires a CA the gorouter trusts", func() {
+				concoursePlan.Properties["tls"] = map[string]interface{}{"enabled": true}
+				_, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).To(MatchError(ContainSubstring("set tls.ca_certificate and tls.ca_private_key")))
+			})
+
+			It("hands the CA out with bindings", func() {
+				generated, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, nil, nil)
+				Expect(generateErr).NotTo(HaveOccurred())
+
+				binding, bindingErr := binder.CreateBinding("some-binding-id", bosh.BoshVMs{}, generated, nil)
+
+				Expect(bindingErr).NotTo(HaveOccurred())
+				Expect(binding.Credentials["ca_cert"]).To(Equal(caCertificate))
+			})
+
+			It("registers the route with the gorouter as tls backend", func() {
+				generated, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).NotTo(HaveOccurred())
+				route := generated.InstanceGroups[0].Properties["route_registrar"].(map[string]interface{})["routes"].([]map[string]interface{})[0]
+				Expect(route).NotTo(HaveKey("port"))
+				Expect(route["tls_port"]).To(Equal(adapter.AtcTLSPort))
+				Expect(route["server_cert_domain_san"]).To(Equal("some-instance-id.systemdomain.com"))
+			})
+
+			It("keeps the certificate across updates", func() {
+				first, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, nil, nil)
+				Expect(generateErr).NotTo(HaveOccurred())
+
+				originalCertificateGenerator := adapter.CurrentCertificateGenerator
+				defer func() { adapter.CurrentCertificateGenerator = originalCertificateGenerator }()
+				adapter.CurrentCertificateGenerator = func(string, *adapter.CertificateAuthority) (adapter.Certificate, error) {
+					return adapter.Certificate{}, errors.New("certificates must not be regenerated")
+				}
+				second, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, &first, nil)
+
+				Expect(generateErr).NotTo(HaveOccurred())
+				Expect(second.InstanceGroups[0].Properties["tls_cert"]).To(Equal(first.InstanceGroups[0].Properties["tls_cert"]))
+				Expect(second.InstanceGroups[0].Properties["tls_key"]).To(Equal(first.InstanceGroups[0].Properties["tls_key"]))
+			})
+
+			It("uses the certificate supplied by the operator", func() {
+				concoursePlan.Properties["tls"] = map[string]interface{}{
+					"certificate": "operator-certificate",
+					"private_key": "operator-key",
+				}
+				generated, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).NotTo(HaveOccurred())
+				Expect(generated.InstanceGroups[0].Properties["tls_cert"]).To(Equal("operator-certificate"))
+				Expect(generated.InstanceGroups[0].Properties["tls_key"]).To(Equal("operator-key"))
+			})
+
+			It("requires the private key of a supplied certificate", func() {
+				concoursePlan.Properties["tls"] = map[string]interface{}{"certificate": "operator-certificate"}
+				_, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).To(MatchError(ContainSubstring("tls.private_key")))
+			})
+
+			It("declares certificate variables when credentials are stored in credhub", func() {
+				concoursePlan.Properties["credential_storage"] = "credhub"
+				concoursePlan.Properties["ingress"] = map[string]interface{}{"mode": "load_balancer", "external_url": "https://{deployment}.systemdomain.com"}
+				concoursePlan.Properties["tls"] = map[string]interface{}{"enabled": true}
+				generated, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).NotTo(HaveOccurred())
+				Expect(generated.Variables).To(ContainElement(bosh.Variable{
+					Name: "/concourse/some-instance-id/tls_certificate",
+					Type: "certificate",
+					Options: map[string]interface{}{
+						"ca":                "/concourse/some-instance-id/tls_ca",
+						"common_name":       "some-instance-id.systemdomain.com",
+						"alternative_names": []string{"some-instance-id.systemdomain.com"},
+					},
+				}))
+				Expect(generated.InstanceGroups[0].Properties["tls_cert"]).To(Equal("((/concourse/some-instance-id/tls_certificate.certificate))"))
+				Expect(generated.InstanceGroups[0].Properties["tls_ca"]).To(Equal(map[string]interface{}{
+					"certificate": "((/concourse/some-instance-id/tls_ca.certificate))",
+				}))
+
+				binder.ConfigPath = getFixturePath("director-credhub.conf")
+				binder.SecretResolver = &fakeSecretResolver{values: map[string]interface{}{
+					"/concourse/some-instance-id/basic_auth_password": "password-from-credhub",
+					"/concourse/some-instance-id/tls_ca.certificate":  "ca-from-credhub",
+				}}
+				binding, bindingErr := binder.CreateBinding("some-binding-id", bosh.BoshVMs{}, generated, nil)
+				Expect(bindingErr).NotTo(HaveOccurred())
+				Expect(binding.Credentials["ca_cert"]).To(Equal("ca-from-credhub"))
+			})
+
+			Context("without a CA of the operator", func() {
+				BeforeEach(func() {
+					concoursePlan.Properties["ingress"] = map[string]interface{}{"mode": "none"}
+					concoursePlan.Properties["tls"] = map[string]interface{}{"enabled": true}
+				})
+
+				It("keeps the generated CA in the manifest and renews the certificate with it", func() {
+					first, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, nil, nil)
+					Expect(generateErr).NotTo(HaveOccurred())
+					ca := first.InstanceGroups[0].Properties["tls_ca"].(map[string]interface{})
+					Expect(ca["private_key"]).To(ContainSubstring("BEGIN RSA PRIVATE KEY"))
+					Expect(parseTestCertificate(first.InstanceGroups[0].Properties["tls_cert"]).CheckSignatureFrom(parseTestCertificate(ca["certificate"]))).To(Succeed())
+
+					first.InstanceGroups[0].Properties["tls_cert"] = "expired-certificate"
+					renewed, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, &first, nil)
+
+					Expect(generateErr).NotTo(HaveOccurred())
+					Expect(renewed.InstanceGroups[0].Properties["tls_ca"]).To(Equal(ca))
+					Expect(parseTestCertificate(renewed.InstanceGroups[0].Properties["tls_cert"]).CheckSignatureFrom(parseTestCertificate(ca["certificate"]))).To(Succeed())
+				})
+
+				It("reissues certificates of instances deployed before the CA was kept", func() {
+					first, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, nil, nil)
+					Expect(generateErr).NotTo(HaveOccurred())
+					delete(first.InstanceGroups[0].Properties, "tls_ca")
+
+					second, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, &first, nil)
+
+					Expect(generateErr).NotTo(HaveOccurred())
+					Expect(second.InstanceGroups[0].Properties["tls_cert"]).NotTo(Equal(first.InstanceGroups[0].Properties["tls_cert"]))
+					ca := second.InstanceGroups[0].Properties["tls_ca"].(map[string]interface{})
+					Expect(parseTestCertificate(second.InstanceGroups[0].Properties["tls_cert"]).CheckSignatureFrom(parseTestCertificate(ca["certificate"]))).To(Succeed())
+				})
+			})
+		})
+
+		Context("uaa login", func() {
+			var registrar *fakeClientRegistrar
+
+			BeforeEach(func() {
+				registrar = &fakeClientRegistrar{}
+				manifestGenerator.ClientRegistrar = registrar
+				concoursePlan.Properties["uaa"] = map[string]interface{}{
+					"url":                 "https://uaa.systemdomain.com/",
+					"cf_api_url":          "https://api.systemdomain.com",
+					"admin_client_id":     "admin",
+					"admin_client_secret": "admin-secret",
+				}
+				defaultRequestParameters["context"] = map[string]interface{}{
+					"platform":          "cloudfoundry",
+					"organization_guid": "some-org-guid",
+					"space_guid":        "some-space-guid",
+				}
+			})
+
+			It("registers a uaa client for the instance", func() {
+				_, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).NotTo(HaveOccurred())
+				Expect(registrar.server.AdminClientID).To(Equal("admin"))
+				Expect(registrar.client.ClientID).To(Equal("concourse-some-instance-id"))
+				Expect(registrar.client.RedirectURI).To(Equal([]string{"https://some-instance-id.systemdomain.com/auth/uaa/callback"}))
+			})
+
+			It("maps the main team to the space of the instance", func() {
+				generated, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).NotTo(HaveOccurred())
+				uaaAuth := generated.InstanceGroups[0].Properties["uaa_auth"].(map[string]interface{})
+				Expect(uaaAuth["client_id"]).To(Equal("concourse-some-instance-id"))
+				Expect(uaaAuth["client_secret"]).To(Equal(registrar.client.ClientSecret))
+				Expect(uaaAuth["auth_url"]).To(Equal("https://uaa.systemdomain.com/oauth/authorize"))
+				Expect(uaaAuth["token_url"]).To(Equal("https://uaa.systemdomain.com/oauth/token"))
+				Expect(uaaAuth["cf_url"]).To(Equal("https://api.systemdomain.com"))
+				Expect(uaaAuth["cf_spaces"]).To(Equal([]string{"some-space-guid"}))
+			})
+
+			It("configures the cf connector on concourse 4 and later", func() {
+				generated, generateErr := generateManifest(manifestGenerator, concourse4ServiceReleases(), concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).NotTo(HaveOccurred())
+				properties := generated.InstanceGroups[0].Properties
+				Expect(properties["cf_auth"]).To(HaveKeyWithValue("api_url", "https://api.systemdomain.com"))
+				Expect(properties["cf_auth"]).To(HaveKeyWithValue("client_id", "concourse-some-instance-id"))
+				Expect(properties).NotTo(HaveKey("cf"))
+				mainTeamAuth := properties["main_team"].(map[string]interface{})["auth"].(map[string]interface{})
+				Expect(mainTeamAuth["cf"]).To(Equal(map[string]interface{}{"space_guids": []string{"some-space-guid"}}))
+				Expect(mainTeamAuth).To(HaveKey("local"))
+				Expect(registrar.client.RedirectURI).To(Equal([]string{"https://some-instance-id.systemdomain.com/sky/issuer/callback"}))
+			})
+
+			It("keeps the client secret and space on updates without a request context", func() {
+				first, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, nil, nil)
+				Expect(generateErr).NotTo(HaveOccurred())
+
+				delete(defaultRequestParameters, "context")
+				second, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, &first, nil)
+
+				Expect(generateErr).NotTo(HaveOccurred())
+				Expect(second.InstanceGroups[0].Properties["uaa_auth"]).To(Equal(first.InstanceGroups[0].Properties["uaa_auth"]))
+			})
+
+			It("keeps the client secret of the cf connector on updates", func() {
+				first, generateErr := generateManifest(manifestGenerator, concourse4ServiceReleases(), concoursePlan, defaultRequestParameters, nil, nil)
+				Expect(generateErr).NotTo(HaveOccurred())
+				secret := first.InstanceGroups[0].Properties["cf_auth"].(map[string]interface{})["client_secret"]
+
+				second, generateErr := generateManifest(manifestGenerator, concourse4ServiceReleases(), concoursePlan, defaultRequestParameters, &first, nil)
+				Expect(generateErr).NotTo(HaveOccurred())
+				Expect(second.InstanceGroups[0].Properties["cf_auth"]).To(HaveKeyWithValue("client_secret", secret))
+
+				first.InstanceGroups[0].Properties["cf"] = first.InstanceGroups[0].Properties["cf_auth"]
+				delete(first.InstanceGroups[0].Properties, "cf_auth")
+				third, generateErr := generateManifest(manifestGenerator, concourse4ServiceReleases(), concoursePlan, defaultRequestParameters, &first, nil)
+				Expect(generateErr).NotTo(HaveOccurred())
+				Expect(third.InstanceGroups[0].Properties["cf_auth"]).To(HaveKeyWithValue("client_secret", secret))
+				Expect(registrar.client.ClientSecret).To(Equal(secret))
+			})
+
+			It("requires the space of the instance", func() {
+				delete(defaultRequestParameters, "context")
+				_, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).To(MatchError(ContainSubstring("space_guid")))
+			})
+
+			It("fails when the client cannot be registered", func() {
+				registrar.err = errors.New("uaa unavailable")
+				_, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).To(MatchError("uaa unavailable"))
+			})
+		})
+
+		Context("local users", func() {
+			BeforeEach(func() {
+				defaultRequestParameters["parameters"] = map[string]interface{}{
+					"local_users": []interface{}{
+						map[string]interface{}{"username": "alice", "password": "alice-password"},
+						map[string]interface{}{"username": "bob", "password": "bob-password"},
+					},
+				}
+			})
+
+			It("adds the requested users to the main team after the admin user", func() {
+				generated, generateErr := generateManifest(manifestGenerator, concourse4ServiceReleases(), concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).NotTo(HaveOccurred())
+				properties := generated.InstanceGroups[0].Properties
+				users := properties["add_local_users"].([]string)
+				Expect(users).To(HaveLen(3))
+				Expect(users[0]).To(HavePrefix("atc:"))
+				Expect(users[1:]).To(Equal([]string{"alice:alice-password", "bob:bob-password"}))
+				local := properties["main_team"].(map[string]interface{})["auth"].(map[string]interface{})["local"]
+				Expect(local).To(Equal(map[string]interface{}{"users": []string{"atc", "alice", "bob"}}))
+			})
+
+			It("keeps the admin password and the users on updates without parameters", func() {
+				first, generateErr := generateManifest(manifestGenerator, concourse4ServiceReleases(), concoursePlan, defaultRequestParameters, nil, nil)
+				Expect(generateErr).NotTo(HaveOccurred())
+
+				defaultRequestParameters["parameters"] = map[string]interface{}{}
+				second, generateErr := generateManifest(manifestGenerator, concourse4ServiceReleases(), concoursePlan, defaultRequestParameters, &first, nil)
+
+				Expect(generateErr).NotTo(HaveOccurred())
+				Expect(second.InstanceGroups[0].Properties["add_local_users"]).To(Equal(first.InstanceGroups[0].Properties["add_local_users"]))
+				Expect(second.InstanceGroups[0].Properties["main_team"]).To(Equal(first.InstanceGroups[0].Properties["main_team"]))
+			})
+
+			It("removes users left out of the parameter", func() {
+				first, generateErr := generateManifest(manifestGenerator, concourse4ServiceReleases(), concoursePlan, defaultRequestParameters, nil, nil)
+				Expect(generateErr).NotTo(HaveOccurred())
+
+				defaultRequestParameters["parameters"] = map[string]interface{}{
+					"local_users": []interface{}{
+						map[string]interface{}{"username": "bob", "password": "bob-password"},
+					},
+				}
+				second, generateErr := generateManifest(manifestGenerator, concourse4ServiceReleases(), concoursePlan, defaultRequestParameters, &first, nil)
+
+				Expect(generateErr).NotTo(HaveOccurred())
+				users := second.InstanceGroups[0].Properties["add_local_users"].([]string)
+				Expect(users).To(Equal([]string{first.InstanceGroups[0].Properties["add_local_users"].([]string)[0], "bob:bob-password"}))
+			})
+
+			It("reserves the admin username", func() {
+				defaultRequestParameters["parameters"] = map[string]interface{}{
+					"local_users": []interface{}{
+						map[string]interface{}{"username": "atc", "password": "mine-now"},
+					},
+				}
+				_, generateErr := generateManifest(manifestGenerator, concourse4ServiceReleases(), concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).To(MatchError(ContainSubstring("username atc is reserved")))
+			})
+
+			It("rejects usernames containing the password separator", func() {
+				defaultRequestParameters["parameters"] = map[string]interface{}{
+					"local_users": []interface{}{
+						map[string]interface{}{"username": "eve:admin", "password": "password"},
+					},
+				}
+				_, generateErr := generateManifest(manifestGenerator, concourse4ServiceReleases(), concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).To(MatchError(ContainSubstring("may only contain")))
+			})
+
+			It("requires concourse 4 or later", func() {
+				_, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).To(MatchError(ContainSubstring("require concourse 4 or later")))
+			})
+
+			Context("when credentials are stored in credhub", func() {
+				var store *fakeSecretStore
+
+				BeforeEach(func() {
+					concoursePlan.Properties["credential_storage"] = "credhub"
+					store = &fakeSecretStore{}
+					manifestGenerator.ConfigPath = getFixturePath("director-credhub.conf")
+					manifestGenerator.SecretStore = store
+				})
+
+				It("stores the passwords in credhub and references them", func() {
+					generated, generateErr := generateManifest(manifestGenerator, concourse4ServiceReleases(), concoursePlan, defaultRequestParameters, nil, nil)
+
+					Expect(generateErr).NotTo(HaveOccurred())
+					Expect(store.server.ClientID).To(Equal("service-adapter"))
+					Expect(store.stored).To(Equal(map[string]interface{}{
+						"/concourse/some-instance-id/local_users/alice": "alice-password",
+						"/concourse/some-instance-id/local_users/bob":   "bob-password",
+					}))
+					Expect(store.types).To(HaveKeyWithValue("/concourse/some-instance-id/local_users/alice", "password"))
+					Expect(generated.InstanceGroups[0].Properties["add_local_users"]).To(Equal([]string{
+						"atc:((/concourse/some-instance-id/basic_auth_password))",
+						"alice:((/concourse/some-instance-id/local_users/alice))",
+						"bob:((/concourse/some-instance-id/local_users/bob))",
+					}))
+				})
+
+				It("requires director_credhub in the adapter config", func() {
+					manifestGenerator.ConfigPath = ""
+					_, generateErr := generateManifest(manifestGenerator, concourse4ServiceReleases(), concoursePlan, defaultRequestParameters, nil, nil)
+
+					Expect(generateErr).To(MatchError(ContainSubstring("director_credhub")))
+					Expect(store.stored).To(BeEmpty())
+				})
+			})
+		})
+
+		Context("teams errand", func() {
+			var releases serviceadapter.ServiceReleases
+
+			BeforeEach(func() {
+				releases = append(concourse4ServiceReleases(), serviceadapter.ServiceRelease{
+					Name: adapter.ErrandsReleaseName, Version: "1", Jobs: []string{adapter.TeamsErrandJobName},
+				})
+				concoursePlan.InstanceGroups = append(concoursePlan.InstanceGroups, serviceadapter.InstanceGroup{
+					Name:      adapter.TeamsErrandInstanceName,
+					VMType:    "small",
+					Networks:  []string{"default_network"},
+					Instances: 1,
+					AZs:       []string{"az1"},
+					Lifecycle: "errand",
+				})
+				concoursePlan.Properties["auth"] = map[string]interface{}{
+					"oidc": map[string]interface{}{
+						"issuer":        "https://login.example.com",
+						"client_id":     "concourse",
+						"client_secret": "oidc-secret",
+					},
+				}
+				defaultRequestParameters["parameters"] = map[string]interface{}{
+					"teams": []interface{}{
+						map[string]interface{}{
+							"name": "dev",
+							"oidc": map[string]interface{}{"groups": []interface{}{"developers"}},
+						},
+						map[string]interface{}{
+							"name":  "ops",
+							"role":  "viewer",
+							"local": map[string]interface{}{"users": []interface{}{"atc"}},
+						},
+					},
+				}
+			})
+
+			It("adds an errand instance group applying the teams", func() {
+				generated, generateErr := generateManifest(manifestGenerator, releases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).NotTo(HaveOccurred())
+				errand := generated.InstanceGroups[3]
+				Expect(errand.Name).To(Equal(adapter.TeamsErrandInstanceName))
+				Expect(errand.Lifecycle).To(Equal("errand"))
+				Expect(errand.Jobs).To(HaveLen(1))
+				Expect(errand.Jobs[0].Name).To(Equal(adapter.TeamsErrandJobName))
+				Expect(errand.Jobs[0].Release).To(Equal(adapter.ErrandsReleaseName))
+				Expect(errand.Properties["concourse"]).To(HaveKeyWithValue("url", "https://some-instance-id.systemdomain.com"))
+				Expect(errand.Properties["concourse"]).To(HaveKeyWithValue("username", "atc"))
+				Expect(errand.Properties["teams"]).To(Equal([]map[string]interface{}{
+					{"name": "dev", "roles": []map[string]interface{}{{
+						"name": "member",
+						"oidc": map[string]interface{}{"users": []string{}, "groups": []string{"developers"}},
+					}}},
+					{"name": "ops", "roles": []map[string]interface{}{{
+						"name":  "viewer",
+						"local": map[string]interface{}{"users": []string{"atc"}},
+					}}},
+				}))
+			})
+
+			It("keeps the teams on updates without parameters", func() {
+				first, generateErr := generateManifest(manifestGenerator, releases, concoursePlan, defaultRequestParameters, nil, nil)
+				Expect(generateErr).NotTo(HaveOccurred())
+
+				defaultRequestParameters["parameters"] = map[string]interface{}{}
+				second, generateErr := generateManifest(manifestGenerator, releases, concoursePlan, defaultRequestParameters, &first, nil)
+
+				Expect(generateErr).NotTo(HaveOccurred())
+				Expect(second.InstanceGroups[3].Properties).To(Equal(first.InstanceGroups[3].Properties))
+			})
+
+			It("reports removed teams instead of deleting them", func() {
+				first, generateErr := generateManifest(manifestGenerator, releases, concoursePlan, defaultRequestParameters, nil, nil)
+				Expect(generateErr).NotTo(HaveOccurred())
+
+				defaultRequestParameters["parameters"] = map[string]interface{}{
+					"teams": []interface{}{
+						map[string]interface{}{"name": "dev", "local": map[string]interface{}{"users": []interface{}{"atc"}}},
+					},
+				}
+				second, generateErr := generateManifest(manifestGenerator, releases, concoursePlan, defaultRequestParameters, &first, nil)
+
+				Expect(generateErr).NotTo(HaveOccurred())
+				Expect(second.InstanceGroups[3].Properties["teams"]).To(HaveLen(1))
+				Expect(second.InstanceGroups[3].Properties["removed_teams"]).To(Equal([]string{"ops"}))
+				Expect(stderr).To(gbytes.Say(`teams \[ops\] are no longer listed`))
+			})
+
+			It("rejects members of providers the plan does not offer", func() {
+				delete(concoursePlan.Properties, "auth")
+				_, generateErr := generateManifest(manifestGenerator, releases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).To(MatchError("teams[0].oidc is not accepted by this plan"))
+			})
+
+			It("rejects unknown roles", func() {
+				defaultRequestParameters["parameters"] = map[string]interface{}{
+					"teams": []interface{}{
+						map[string]interface{}{"name": "dev", "role": "admin", "local": map[string]interface{}{"users": []interface{}{"atc"}}},
+					},
+				}
+				_, generateErr := generateManifest(manifestGenerator, releases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).To(MatchError(ContainSubstring("must be owner, member, pipeline-operator or viewer")))
+			})
+
+			It("reserves the main team", func() {
+				defaultRequestParameters["parameters"] = map[string]interface{}{
+					"teams": []interface{}{
+						map[string]interface{}{"name": "main", "local": map[string]interface{}{"users": []interface{}{"atc"}}},
+					},
+				}
+				_, generateErr := generateManifest(manifestGenerator, releases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).To(MatchError(ContainSubstring("team main is reserved")))
+			})
+
+			It("requires the errand instance group when teams are requested", func() {
+				concoursePlan.InstanceGroups = concoursePlan.InstanceGroups[:3]
+				_, generateErr := generateManifest(manifestGenerator, releases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).To(MatchError("teams is not accepted by this plan"))
+			})
+
+			It("reports every problem of the parameters at once", func() {
+				defaultRequestParameters["parameters"] = map[string]interface{}{
+					"teams": []interface{}{
+						map[string]interface{}{"name": "main", "role": "admin", "local": map[string]interface{}{"users": []interface{}{"atc"}}},
+					},
+					"local_users": []interface{}{
+						map[string]interface{}{"username": "alice"},
+					},
+				}
+				_, generateErr := generateManifest(manifestGenerator, releases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).To(MatchError(ContainSubstring("local_users[0].password must be set")))
+				Expect(generateErr).To(MatchError(ContainSubstring("team main is reserved")))
+				Expect(generateErr).To(MatchError(ContainSubstring(`role "admin" of team main`)))
+			})
+
+			It("provisions nothing when a job of the manifest is missing", func() {
+				provisioner := &fakeDatabaseProvisioner{}
+				manifestGenerator.DatabaseProvisioner = provisioner
+				concoursePlan.Properties["external_database"] = map[string]interface{}{
+					"host":           "postgres.example.com",
+					"admin_username": "admin",
+					"admin_password": "admin-password",
+				}
+				_, generateErr := generateManifest(manifestGenerator, concourse4ServiceReleases(), concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).To(MatchError(ContainSubstring("no release provided for job " + adapter.TeamsErrandJobName)))
+				Expect(provisioner.database).To(BeEmpty())
+			})
+		})
+
+		Context("bootstrap pipeline errand", func() {
+			var releases serviceadapter.ServiceReleases
+
+			BeforeEach(func() {
+				releases = append(defaultServiceReleases, serviceadapter.ServiceRelease{
+					Name: adapter.ErrandsReleaseName, Version: "1", Jobs: []string{adapter.SetPipelineJobName},
+				})
+				concoursePlan.InstanceGroups = append(concoursePlan.InstanceGroups, serviceadapter.InstanceGroup{
+					Name:      adapter.BootstrapErrandInstanceName,
+					VMType:    "small",
+					Networks:  []string{"default_network"},
+					Instances: 1,
+					AZs:       []string{"az1"},
+					Lifecycle: "errand",
+				})
+				defaultRequestParameters["parameters"] = map[string]interface{}{
+					"bootstrap_pipeline": map[string]interface{}{
+						"git": map[string]interface{}{
+							"uri":  "https://github.com/acme/pipelines.git",
+							"path": "ci/pipeline-of-pipelines.yml",
+						},
+						"vars": map[string]interface{}{"environment": "dev"},
+					},
+				}
+			})
+
+			It("sets and unpauses the pipeline on the main team", func() {
+				generated, generateErr := generateManifest(manifestGenerator, releases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).NotTo(HaveOccurred())
+				errand := generated.InstanceGroups[3]
+				Expect(errand.Name).To(Equal(adapter.BootstrapErrandInstanceName))
+				Expect(errand.Lifecycle).To(Equal("errand"))
+				Expect(errand.Jobs[0].Name).To(Equal(adapter.SetPipelineJobName))
+				Expect(errand.Properties["team"]).To(Equal("main"))
+				Expect(errand.Properties["unpause"]).To(BeTrue())
+				Expect(errand.Properties["overwrite"]).To(BeTrue())
+				Expect(errand.Properties["concourse"]).To(HaveKeyWithValue("username", "atc"))
+				Expect(errand.Properties["pipeline"]).To(Equal(map[string]interface{}{
+					"name": "bootstrap",
+					"git": map[string]interface{}{
+						"uri":  "https://github.com/acme/pipelines.git",
+						"path": "ci/pipeline-of-pipelines.yml",
+					},
+					"vars": map[string]interface{}{"environment": "dev"},
+				}))
+			})
+
+			It("passes an inline config", func() {
+				defaultRequestParameters["parameters"] = map[string]interface{}{
+					"bootstrap_pipeline": map[string]interface{}{
+						"name":   "self-update",
+						"config": "jobs:\n- name: hello\n  plan: []\n",
+					},
+				}
+				generated, generateErr := generateManifest(manifestGenerator, releases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).NotTo(HaveOccurred())
+				pipeline := generated.InstanceGroups[3].Properties["pipeline"].(map[string]interface{})
+				Expect(pipeline["name"]).To(Equal("self-update"))
+				Expect(pipeline["config"]).To(Equal("jobs:\n- name: hello\n  plan: []\n"))
+				Expect(pipeline).NotTo(HaveKey("git"))
+			})
+
+			It("does not overwrite the pipeline on updates without the parameter", func() {
+				first, generateErr := generateManifest(manifestGenerator, releases, concoursePlan, defaultRequestParameters, nil, nil)
+				Expect(generateErr).NotTo(HaveOccurred())
+
+				defaultRequestParameters["parameters"] = map[string]interface{}{}
+				second, generateErr := generateManifest(manifestGenerator, releases, concoursePlan, defaultRequestParameters, &first, nil)
+
+				Expect(generateErr).NotTo(HaveOccurred())
+				Expect(second.InstanceGroups[3].Properties["pipeline"]).To(Equal(first.InstanceGroups[3].Properties["pipeline"]))
+				Expect(second.InstanceGroups[3].Properties["overwrite"]).To(BeFalse())
+			})
+
+			It("rejects invalid inline yaml", func() {
+				defaultRequestParameters["parameters"] = map[string]interface{}{
+					"bootstrap_pipeline": map[string]interface{}{"config": "jobs: [unclosed"},
+				}
+				_, generateErr := generateManifest(manifestGenerator, releases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).To(MatchError(ContainSubstring("bootstrap_pipeline.config is not valid yaml")))
+			})
+
+			It("requires either an inline config or a git source", func() {
+				defaultRequestParameters["parameters"] = map[string]interface{}{
+					"bootstrap_pipeline": map[string]interface{}{"name": "bootstrap"},
+				}
+				_, generateErr := generateManifest(manifestGenerator, releases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).To(MatchError("bootstrap_pipeline: exactly one of config and git must be set"))
+			})
+
+			It("requires the errand instance group when a pipeline is requested", func() {
+				concoursePlan.InstanceGroups = concoursePlan.InstanceGroups[:3]
+				_, generateErr := generateManifest(manifestGenerator, releases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).To(MatchError("bootstrap_pipeline is not accepted by this plan"))
+			})
+
+			It("keeps the private key and the vars in credhub when credentials are stored there", func() {
+				store := &fakeSecretStore{}
+				manifestGenerator.ConfigPath = getFixturePath("director-credhub.conf")
+				manifestGenerator.SecretStore = store
+				concoursePlan.Properties["credential_storage"] = "credhub"
+				defaultRequestParameters["parameters"] = map[string]interface{}{
+					"bootstrap_pipeline": map[string]interface{}{
+						"git": map[string]interface{}{
+							"uri":         "git@github.com:acme/pipelines.git",
+							"path":        "ci/pipeline-of-pipelines.yml",
+							"private_key": "deploy-key",
+						},
+						"vars": map[string]interface{}{"token": "s3cret"},
+					},
+				}
+				generated, generateErr := generateManifest(manifestGenerator, releases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).NotTo(HaveOccurred())
+				Expect(generated.InstanceGroups[3].Properties["pipeline"]).To(Equal(map[string]interface{}{
+					"name": "bootstrap",
+					"git": map[string]interface{}{
+						"uri":         "git@github.com:acme/pipelines.git",
+						"path":        "ci/pipeline-of-pipelines.yml",
+						"private_key": "((/concourse/some-instance-id/bootstrap_pipeline/git_private_key))",
+					},
+					"vars": "((/concourse/some-instance-id/bootstrap_pipeline/vars))",
+				}))
+				Expect(store.stored).To(Equal(map[string]interface{}{
+					"/concourse/some-instance-id/bootstrap_pipeline/git_private_key": "deploy-key",
+					"/concourse/some-instance-id/bootstrap_pipeline/vars":            map[string]interface{}{"token": "s3cret"},
+				}))
+				Expect(store.types).To(Equal(map[string]string{
+					"/concourse/some-instance-id/bootstrap_pipeline/git_private_key": "value",
+					"/concourse/some-instance-id/bootstrap_pipeline/vars":            "json",
+				}))
+			})
+		})
+
+		Context("credential manager", func() {
+			It("points atc at credhub below a path of the instance", func() {
+				concoursePlan.Properties["credential_manager"] = map[string]interface{}{
+					"type":          "credhub",
+					"url":           "https://credhub.service.cf.internal:8844",
+					"ca_cert":       "credhub-ca",
+					"client_id":     "concourse_to_credhub",
+					"client_secret": "credhub-secret",
+				}
+				generated, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).NotTo(HaveOccurred())
+				Expect(generated.InstanceGroups[0].Properties["credhub"]).To(Equal(map[string]interface{}{
+					"url":           "https://credhub.service.cf.internal:8844",
+					"path_prefix":   "/concourse/some-instance-id",
+					"client_id":     "concourse_to_credhub",
+					"client_secret": "credhub-secret",
+					"tls": map[string]interface{}{
+						"ca_cert": map[string]interface{}{"certificate": "credhub-ca"},
+					},
+				}))
+			})
+
+			It("authenticates against vault with an approle", func() {
+				concoursePlan.Properties["credential_manager"] = map[string]interface{}{
+					"type":          "vault",
+					"url":           "https://vault.example.com:8200",
+					"client_id":     "role-id",
+					"client_secret": "secret-id",
+					"path_prefix":   "/tenants/",
+				}
+				generated, generateErr := generateManifest(manifestGenerator, concourse4ServiceReleases(), concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).NotTo(HaveOccurred())
+				vault := generated.InstanceGroups[0].Properties["vault"].(map[string]interface{})
+				Expect(vault["path_prefix"]).To(Equal("/tenants/some-instance-id"))
+				Expect(vault["auth"]).To(Equal(map[string]interface{}{
+					"backend": "approle",
+					"params":  map[string]interface{}{"role_id": "role-id", "secret_id": "secret-id"},
+				}))
+				Expect(generated.InstanceGroups[0].Properties).NotTo(HaveKey("credhub"))
+			})
+
+			It("requires credentials of the credential manager", func() {
+				concoursePlan.Properties["credential_manager"] = map[string]interface{}{
+					"type": "vault",
+					"url":  "https://vault.example.com:8200",
+				}
+				_, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).To(MatchError(ContainSubstring("credential_manager.client_token must be set")))
+			})
+
+			It("rejects unknown credential managers", func() {
+				concoursePlan.Properties["credential_manager"] = map[string]interface{}{
+					"type": "aws-ssm",
+					"url":  "https://ssm.example.com",
+				}
+				_, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).To(MatchError("credential_manager.type must be credhub or vault"))
+			})
+		})
+
+		Context("dedicated credhub", func() {
+			var releases serviceadapter.ServiceReleases
+
+			BeforeEach(func() {
+				releases = append(concourse4ServiceReleases(), serviceadapter.ServiceRelease{
+					Name: adapter.CredhubReleaseName, Version: "2", Jobs: []string{adapter.CredhubJobName},
+				})
+				concoursePlan.Properties["credhub"] = map[string]interface{}{"enabled": true, "colocate": true}
+			})
+
+			It("colocates credhub and its database on the db instance group", func() {
+				generated, generateErr := generateManifest(manifestGenerator, releases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).NotTo(HaveOccurred())
+				Expect(generated.InstanceGroups).To(HaveLen(3))
+				db := generated.InstanceGroups[1]
+				Expect(db.Jobs).To(HaveLen(2))
+				Expect(db.Jobs[1].Name).To(Equal(adapter.CredhubJobName))
+				Expect(db.Jobs[1].Release).To(Equal(adapter.CredhubReleaseName))
+				databases := db.Properties["databases"].(map[string]interface{})
+				Expect(databases["databases"]).To(ContainElement(map[string]interface{}{"name": "credhub"}))
+				credhub := db.Properties["credhub"].(map[string]interface{})
+				Expect(credhub["data_storage"]).To(HaveKeyWithValue("host", "127.0.0.1"))
+				Expect(credhub["data_storage"]).To(HaveKeyWithValue("database", "credhub"))
+				Expect(credhub["authentication"]).To(HaveKeyWithValue("uaa", map[string]interface{}{"enabled": false}))
+			})
+
+			It("wires atc to credhub with a client certificate", func() {
+				generated, generateErr := generateManifest(manifestGenerator, releases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).NotTo(HaveOccurred())
+				atcCredhub := generated.InstanceGroups[0].Properties["credhub"].(map[string]interface{})
+				Expect(atcCredhub["url"]).To(Equal("https://q-s0.db.default-network.some-instance-id.bosh:8844"))
+				tls := atcCredhub["tls"].(map[string]interface{})
+				ca := tls["ca_cert"].(map[string]interface{})["certificate"].(string)
+				clientCertificate := tls["client_cert"].(map[string]interface{})["certificate"].(string)
+
+				roots := x509.NewCertPool()
+				Expect(roots.AppendCertsFromPEM([]byte(ca))).To(BeTrue())
+				block, _ := pem.Decode([]byte(clientCertificate))
+				certificate, err := x509.ParseCertificate(block.Bytes)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(certificate.Subject.OrganizationalUnit).To(ConsistOf(HavePrefix("app:")))
+				_, err = certificate.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+				Expect(err).NotTo(HaveOccurred())
+
+				credhub := generated.InstanceGroups[1].Properties["credhub"].(map[string]interface{})
+				Expect(credhub["authentication"].(map[string]interface{})["mutual_tls"]).To(Equal(map[string]interface{}{"trusted_cas": []string{ca}}))
+			})
+
+			It("keeps keys and certificates stable across updates", func() {
+				first, generateErr := generateManifest(manifestGenerator, releases, concoursePlan, defaultRequestParameters, nil, nil)
+				Expect(generateErr).NotTo(HaveOccurred())
+
+				second, generateErr := generateManifest(manifestGenerator, releases, concoursePlan, defaultRequestParameters, &first, nil)
+				Expect(generateErr).NotTo(HaveOccurred())
+				Expect(second.InstanceGroups[1].Properties["credhub"]).To(Equal(first.InstanceGroups[1].Properties["credhub"]))
+				Expect(second.InstanceGroups[0].Properties["credhub"]).To(Equal(first.InstanceGroups[0].Properties["credhub"]))
+			})
+
+			It("runs credhub on its own instance group", func() {
+				concoursePlan.Properties["credhub"] = map[string]interface{}{"enabled": true}
+				concoursePlan.InstanceGroups = append(concoursePlan.InstanceGroups, serviceadapter.InstanceGroup{
+					Name:      adapter.CredhubInstanceName,
+					VMType:    "small",
+					Networks:  []string{"default_network"},
+					Instances: 1,
+					AZs:       []string{"az1"},
+				})
+				generated, generateErr := generateManifest(manifestGenerator, releases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).NotTo(HaveOccurred())
+				Expect(generated.InstanceGroups[1].Jobs).To(HaveLen(1))
+				credhubGroup := generated.InstanceGroups[2]
+				Expect(credhubGroup.Name).To(Equal(adapter.CredhubInstanceName))
+				Expect(credhubGroup.Jobs[0].Name).To(Equal(adapter.CredhubJobName))
+				dataStorage := credhubGroup.Properties["credhub"].(map[string]interface{})["data_storage"]
+				Expect(dataStorage).To(HaveKeyWithValue("host", "q-s0.db.default-network.some-instance-id.bosh"))
+				Expect(generated.InstanceGroups[0].Properties["credhub"]).To(HaveKeyWithValue("url", "https://q-s0.credhub.default-network.some-instance-id.bosh:8844"))
+			})
+
+			It("provisions a credhub database on an external database", func() {
+				provisioner := &fakeDatabaseProvisioner{}
+				manifestGenerator.DatabaseProvisioner = provisioner
+				concoursePlan.Properties["credhub"] = map[string]interface{}{"enabled": true}
+				concoursePlan.InstanceGroups = append(concoursePlan.InstanceGroups, serviceadapter.InstanceGroup{
+					Name: adapter.CredhubInstanceName, VMType: "small", Networks: []string{"default_network"}, Instances: 1,
+				})
+				concoursePlan.Properties["external_database"] = map[string]interface{}{
+					"host":           "postgres.example.com",
+					"admin_username": "admin",
+					"admin_password": "admin-password",
+				}
+				generated, generateErr := generateManifest(manifestGenerator, releases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).NotTo(HaveOccurred())
+				Expect(provisioner.database).To(Equal("concourse_some_instance_id_credhub"))
+				dataStorage := generated.InstanceGroups[1].Properties["credhub"].(map[string]interface{})["data_storage"]
+				Expect(dataStorage).To(HaveKeyWithValue("host", "postgres.example.com"))
+				Expect(dataStorage).To(HaveKeyWithValue("password", provisioner.password))
+			})
+
+			It("replaces the credential manager of the config file", func() {
+				configFile, err := ioutil.TempFile("", "service-adapter.conf")
+				Expect(err).NotTo(HaveOccurred())
+				defer os.Remove(configFile.Name())
+				_, err = configFile.WriteString("credential_manager: {type: vault, url: https://vault.example.com, client_token: vault-token}\n")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(configFile.Close()).To(Succeed())
+				manifestGenerator.ConfigPath = configFile.Name()
+				generated, generateErr := generateManifest(manifestGenerator, releases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).NotTo(HaveOccurred())
+				properties := generated.InstanceGroups[0].Properties
+				Expect(properties).NotTo(HaveKey("vault"))
+				Expect(properties["credhub"]).To(HaveKeyWithValue("url", "https://q-s0.db.default-network.some-instance-id.bosh:8844"))
+			})
+
+			It("cannot be combined with another credential manager", func() {
+				concoursePlan.Properties["credential_manager"] = map[string]interface{}{"type": "credhub"}
+				_, generateErr := generateManifest(manifestGenerator, releases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).To(MatchError(ContainSubstring("credhub: a dedicated credhub cannot be combined with credential_manager")))
+			})
+
+			It("requires the credhub instance group unless colocated", func() {
+				concoursePlan.Properties["credhub"] = map[string]interface{}{"enabled": true}
+				_, generateErr := generateManifest(manifestGenerator, releases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).To(MatchError(ContainSubstring("the plan has no credhub instance group")))
+			})
+		})
+
+		Context("tsa tcp route", func() {
+			var portDir string
+
+			BeforeEach(func() {
+				var err error
+				portDir, err = ioutil.TempDir("", "tcp-ports")
+				Expect(err).NotTo(HaveOccurred())
+				manifestGenerator.PortAllocator = adapter.FilePortAllocator{Dir: portDir}
+				concoursePlan.Properties["tsa_tcp_route"] = map[string]interface{}{
+					"router_group":  "default-tcp",
+					"domain":        "tcp.systemdomain.com",
+					"min_port":      1024,
+					"max_port":      1025,
+					"client_id":     "tcp_emitter",
+					"client_secret": "tcp-secret",
+				}
+			})
+
+			AfterEach(func() {
+				os.RemoveAll(portDir)
+			})
+
+			It("registers a tcp route of the tsa with the router group", func() {
+				generated, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).NotTo(HaveOccurred())
+				routeRegistrar := generated.InstanceGroups[0].Properties["route_registrar"].(map[string]interface{})
+				routes := routeRegistrar["routes"].([]map[string]interface{})
+				Expect(routes).To(HaveLen(2))
+				Expect(routes[1]).To(Equal(map[string]interface{}{
+					"name":                  "concourse-tsa",
+					"type":                  "tcp",
+					"port":                  adapter.TsaPort,
+					"external_port":         1024,
+					"router_group":          "default-tcp",
+					"registration_interval": "20s",
+				}))
+				Expect(routeRegistrar["routing_api"]).To(HaveKeyWithValue("client_id", "tcp_emitter"))
+				Expect(generated.InstanceGroups[0].Jobs[2].Consumes).To(HaveKeyWithValue("routing_api", bosh.ConsumesLink{From: "routing_api", Deployment: "cfdeployment"}))
+			})
+
+			It("keeps the external port across updates", func() {
+				first, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, nil, nil)
+				Expect(generateErr).NotTo(HaveOccurred())
+
+				manifestGenerator.PortAllocator = nil
+				second, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, &first, nil)
+				Expect(generateErr).NotTo(HaveOccurred())
+				Expect(second.InstanceGroups[0].Properties["external_tsa"]).To(Equal(map[string]interface{}{"host": "tcp.systemdomain.com", "port": 1024}))
+			})
+
+			It("hands out a port per instance", func() {
+				allocator := adapter.FilePortAllocator{Dir: portDir}
+				Expect(allocator.Allocate("other-instance", 1024, 1025)).To(Equal(1024))
+				generated, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).NotTo(HaveOccurred())
+				Expect(generated.InstanceGroups[0].Properties["external_tsa"]).To(HaveKeyWithValue("port", 1025))
+				Expect(allocator.Allocate("some-instance-id", 1024, 1025)).To(Equal(1025))
+				_, err := allocator.Allocate("third-instance", 1024, 1025)
+				Expect(err).To(MatchError("no free tcp router port left between 1024 and 1025"))
+			})
+
+			It("returns the external address in the binding", func() {
+				keyStoreDir, err := ioutil.TempDir("", "worker-keys")
+				Expect(err).NotTo(HaveOccurred())
+				defer os.RemoveAll(keyStoreDir)
+				binder.WorkerKeyStore = adapter.FileWorkerKeyStore{Dir: keyStoreDir}
+				generated, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, nil, nil)
+				Expect(generateErr).NotTo(HaveOccurred())
+
+				binding, bindErr := binder.CreateBinding("some-binding", bosh.BoshVMs{}, generated, serviceadapter.RequestParameters{
+					"parameters": map[string]interface{}{"external_worker": true},
+				})
+				Expect(bindErr).NotTo(HaveOccurred())
+				Expect(binding.Credentials["tsa_external_host"]).To(Equal("tcp.systemdomain.com"))
+				Expect(binding.Credentials["tsa_external_port"]).To(Equal(1024))
+				Expect(binding.Credentials["tsa_host"]).To(Equal("tcp.systemdomain.com"))
+				Expect(binding.Credentials["tsa_port"]).To(Equal(1024))
+			})
+		})
+
+		Context("adapter config", func() {
+			var (
+				releases   serviceadapter.ServiceReleases
+				trustedCAs string
+				jobNames   = func(instanceGroup bosh.InstanceGroup) []string {
+					names := []string{}
+					for _, job := range instanceGroup.Jobs {
+						names = append(names, job.Name)
+					}
+					return names
+				}
+			)
+
+			BeforeEach(func() {
+				manifestGenerator = createManifestGenerator("service-adapter-defaults.conf", stderrLogger)
+				releases = append(concourse4ServiceReleases(), serviceadapter.ServiceRelease{
+					Name: "syslog", Version: "11", Jobs: []string{adapter.SyslogForwarderJobName},
+				})
+				content, err := ioutil.ReadFile(getFixturePath("service-adapter-defaults.conf"))
+				Expect(err).NotTo(HaveOccurred())
+				config := map[string]interface{}{}
+				Expect(yaml.Unmarshal(content, &config)).To(Succeed())
+				trustedCAs = config["trusted_cas"].(string)
+			})
+
+			It("defaults the plan with the settings of the config file", func() {
+				generated, generateErr := generateManifest(manifestGenerator, releases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).NotTo(HaveOccurred())
+				web := generated.InstanceGroups[0]
+				Expect(web.Properties["external_url"]).To(Equal("http://q-s0.web.default-network.some-instance-id.bosh:8080"))
+				for _, instanceGroup := range generated.InstanceGroups {
+					Expect(jobNames(instanceGroup)).To(ContainElement(adapter.SyslogForwarderJobName))
+					Expect(instanceGroup.Properties["syslog"]).To(Equal(map[string]interface{}{
+						"address":        "logs.example.com",
+						"port":           6514,
+						"transport":      "tcp",
+						"tls_enabled":    true,
+						"permitted_peer": "*.example.com",
+						"ca_cert":        trustedCAs,
+					}))
+				}
+			})
+
+			It("lets the profiles of the plan and the plan itself override the settings in order", func() {
+				concoursePlan.Properties["profiles"] = []interface{}{"gorouter", "no-syslog"}
+				generated, generateErr := generateManifest(manifestGenerator, releases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).NotTo(HaveOccurred())
+				Expect(generated.InstanceGroups[0].Properties["external_url"]).To(Equal("https://some-instance-id.systemdomain.com"))
+				Expect(jobNames(generated.InstanceGroups[0])).NotTo(ContainElement(adapter.SyslogForwarderJobName))
+
+				concoursePlan.Properties["ingress"] = map[string]interface{}{"mode": "none"}
+				generated, generateErr = generateManifest(manifestGenerator, releases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).NotTo(HaveOccurred())
+				Expect(generated.InstanceGroups[0].Properties["external_url"]).To(Equal("http://q-s0.web.default-network.some-instance-id.bosh:8080"))
+			})
+
+			It("lets tenants forward the logs to their own syslog target", func() {
+				concoursePlan.Properties["tenant_settings"] = []interface{}{"syslog"}
+				defaultRequestParameters["parameters"] = map[string]interface{}{
+					"syslog": map[string]interface{}{"address": "logs.tenant.example.com"},
+				}
+				generated, generateErr := generateManifest(manifestGenerator, releases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).NotTo(HaveOccurred())
+				Expect(generated.InstanceGroups[0].Properties["syslog"]).To(Equal(map[string]interface{}{
+					"address":     "logs.tenant.example.com",
+					"port":        adapter.DefaultSyslogPort,
+					"transport":   adapter.DefaultSyslogTransport,
+					"tls_enabled": false,
+				}))
+			})
+
+			It("keeps the syslog target of the operator unless the plan lets tenants change it", func() {
+				defaultRequestParameters["parameters"] = map[string]interface{}{
+					"syslog": map[string]interface{}{"address": "logs.tenant.example.com"},
+				}
+				_, generateErr := generateManifest(manifestGenerator, releases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).To(MatchError("syslog: the plan does not let tenants change it"))
+			})
+
+			It("does not let tenants turn syslog off", func() {
+				concoursePlan.Properties["tenant_settings"] = []interface{}{"syslog"}
+				defaultRequestParameters["parameters"] = map[string]interface{}{"syslog": nil}
+				_, generateErr := generateManifest(manifestGenerator, releases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).To(MatchError("syslog: tenants cannot unset it"))
+			})
+
+			It("rejects tenant settings tenants cannot change", func() {
+				concoursePlan.Properties["tenant_settings"] = []interface{}{"ingress"}
+				_, generateErr := generateManifest(manifestGenerator, releases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).To(MatchError("tenant_settings: tenants cannot change ingress, only [syslog]"))
+			})
+
+			It("trusts the certificate authorities of the config file unless a setting names its own", func() {
+				concoursePlan.Properties["auth"] = map[string]interface{}{
+					"oidc": map[string]interface{}{"issuer": "https://login.example.com", "client_id": "concourse", "client_secret": "oidc-secret"},
+					"ldap": map[string]interface{}{
+						"host":        "ldap.example.com:636",
+						"bind_dn":     "cn=admin,dc=example,dc=com",
+						"bind_pw":     "ldap-secret",
+						"ca_cert":     "ldap-ca",
+						"user_search": map[string]interface{}{"base_dn": "ou=people,dc=example,dc=com", "username": "uid"},
+					},
+				}
+				generated, generateErr := generateManifest(manifestGenerator, releases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).NotTo(HaveOccurred())
+				properties := generated.InstanceGroups[0].Properties
+				Expect(properties["generic_oidc"]).To(HaveKeyWithValue("ca_cert", map[string]interface{}{"certificate": trustedCAs}))
+				Expect(properties["ldap_auth"]).To(HaveKeyWithValue("ca_cert", map[string]interface{}{"certificate": "ldap-ca"}))
+			})
+
+			It("rejects profiles the config file does not define", func() {
+				concoursePlan.Properties["profiles"] = []interface{}{"large"}
+				_, generateErr := generateManifest(manifestGenerator, releases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).To(MatchError("profiles: large is not defined in the adapter config, known profiles are [gorouter no-syslog]"))
+			})
+
+			It("ignores unknown settings of the config file with a warning", func() {
+				configFile, err := ioutil.TempFile("", "service-adapter.conf")
+				Expect(err).NotTo(HaveOccurred())
+				defer os.Remove(configFile.Name())
+				_, err = configFile.WriteString("app_domain: example.com\n")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(configFile.Close()).To(Succeed())
+				manifestGenerator.ConfigPath = configFile.Name()
+				generated, generateErr := generateManifest(manifestGenerator, releases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).NotTo(HaveOccurred())
+				Expect(generated.InstanceGroups[0].Properties["external_url"]).To(Equal("https://some-instance-id.systemdomain.com"))
+				Expect(stderr).To(gbytes.Say("ignoring unknown setting app_domain"))
+			})
+		})
+
+		Context("plan configuration", func() {
+			It("reports every problem of the plan at once", func() {
+				delete(concoursePlan.Properties, "app_domain")
+				concoursePlan.Properties["tls"] = "yes"
+				concoursePlan.InstanceGroups = concoursePlan.InstanceGroups[2:]
+				_, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).To(HaveOccurred())
+				Expect(generateErr.Error()).To(ContainSubstring("ingress: app_domain must be set for gorouter ingress"))
+				Expect(generateErr.Error()).To(ContainSubstring("tls: "))
+				Expect(generateErr.Error()).To(ContainSubstring("plan has no web instance group"))
+				Expect(generateErr.Error()).To(ContainSubstring("plan has no db instance group"))
+			})
+
+			It("fails instead of panicking without a web instance group", func() {
+				concoursePlan.InstanceGroups = concoursePlan.InstanceGroups[1:]
+				_, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).To(MatchError("plan has no web instance group"))
+			})
+
+			It("reports problems of the plan to the user and logs them for the operator", func() {
+				concoursePlan.Properties["cf_deployment"] = 42
+				_, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).To(MatchError("ingress: cf_deployment must be set for gorouter ingress"))
+				Expect(stderr).To(gbytes.Say("generating the manifest of some-instance-id: ingress: cf_deployment must be set"))
+			})
+
+			It("reports missing jobs the same way as problems of the plan", func() {
+				_, generateErr := generateManifest(manifestGenerator, serviceadapter.ServiceReleases{}, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).To(MatchError(ContainSubstring("no release provided for job")))
+				Expect(stderr).To(gbytes.Say("generating the manifest of some-instance-id: no release provided for job"))
+			})
+		})
+
+		Context("ingress", func() {
+			jobNames := func(instanceGroup bosh.InstanceGroup) []string {
+				names := []string{}
+				for _, job := range instanceGroup.Jobs {
+					names = append(names, job.Name)
+				}
+				return names
+			}
+
+			It("fails instead of panicking when gorouter ingress lacks the cf deployment", func() {
+				delete(concoursePlan.Properties, "cf_deployment")
+				_, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).To(MatchError("ingress: cf_deployment must be set for gorouter ingress"))
+			})
+
+			It("leaves routing to the load balancer of the vm extensions", func() {
+				delete(concoursePlan.Properties, "cf_deployment")
+				delete(concoursePlan.Properties, "app_domain")
+				concoursePlan.Properties["ingress"] = map[string]interface{}{
+					"mode":         "load_balancer",
+					"external_url": "https://{deployment}.ci.example.com",
+				}
+				concoursePlan.InstanceGroups[0].VMExtensions = []string{"concourse-lb"}
+				generated, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).NotTo(HaveOccurred())
+				web := generated.InstanceGroups[0]
+				Expect(jobNames(web)).To(Equal([]string{adapter.AtcJobName, adapter.TsaJobName}))
+				Expect(web.VMExtensions).To(Equal([]string{"concourse-lb"}))
+				Expect(web.Properties).NotTo(HaveKey("route_registrar"))
+				Expect(web.Properties["external_url"]).To(Equal("https://some-instance-id.ci.example.com"))
+			})
+
+			It("exposes the bosh dns address without ingress", func() {
+				concoursePlan.Properties = map[string]interface{}{
+					"ingress": map[string]interface{}{"mode": "none"},
+				}
+				generated, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).NotTo(HaveOccurred())
+				web := generated.InstanceGroups[0]
+				Expect(jobNames(web)).NotTo(ContainElement(adapter.RouteRegisterJobName))
+				Expect(web.Properties["external_url"]).To(Equal("http://q-s0.web.default-network.some-instance-id.bosh:8080"))
+			})
+
+			It("serves the bosh dns address over tls when the plan enables it", func() {
+				concoursePlan.Properties = map[string]interface{}{
+					"ingress": map[string]interface{}{"mode": "none"},
+					"tls":     map[string]interface{}{"enabled": true},
+				}
+				generated, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).NotTo(HaveOccurred())
+				properties := generated.InstanceGroups[0].Properties
+				Expect(properties["external_url"]).To(Equal("https://q-s0.web.default-network.some-instance-id.bosh:4443"))
+				block, _ := pem.Decode([]byte(properties["tls_cert"].(string)))
+				certificate, err := x509.ParseCertificate(block.Bytes)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(certificate.DNSNames).To(ContainElement("q-s0.web.default-network.some-instance-id.bosh"))
+			})
+
+			It("moves to the gorouter route when an instance without ingress gets gorouter ingress", func() {
+				routedProperties := concoursePlan.Properties
+				concoursePlan.Properties = map[string]interface{}{
+					"ingress": map[string]interface{}{"mode": "none"},
+				}
+				first, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, nil, nil)
+				Expect(generateErr).NotTo(HaveOccurred())
+
+				concoursePlan.Properties = routedProperties
+				second, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, &first, nil)
+
+				Expect(generateErr).NotTo(HaveOccurred())
+				properties := second.InstanceGroups[0].Properties
+				Expect(properties["external_url"]).To(Equal("https://some-instance-id.systemdomain.com"))
+				routes := properties["route_registrar"].(map[string]interface{})["routes"].([]map[string]interface{})
+				Expect(routes[0]["uris"]).To(Equal([]string{"some-instance-id.systemdomain.com"}))
+			})
+
+			It("drops the route url when a routed instance loses its ingress", func() {
+				first, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, nil, nil)
+				Expect(generateErr).NotTo(HaveOccurred())
+
+				concoursePlan.Properties = map[string]interface{}{
+					"ingress": map[string]interface{}{"mode": "none"},
+				}
+				second, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, &first, nil)
+
+				Expect(generateErr).NotTo(HaveOccurred())
+				Expect(second.InstanceGroups[0].Properties["external_url"]).To(Equal("http://q-s0.web.default-network.some-instance-id.bosh:8080"))
+			})
+
+			It("moves to https when tls is enabled on a deployed instance", func() {
+				concoursePlan.Properties = map[string]interface{}{
+					"ingress": map[string]interface{}{"mode": "none"},
+				}
+				first, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, nil, nil)
+				Expect(generateErr).NotTo(HaveOccurred())
+
+				concoursePlan.Properties["tls"] = map[string]interface{}{"enabled": true}
+				second, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, &first, nil)
+				Expect(generateErr).NotTo(HaveOccurred())
+				Expect(second.InstanceGroups[0].Properties["external_url"]).To(Equal("https://q-s0.web.default-network.some-instance-id.bosh:4443"))
+
+				delete(concoursePlan.Properties, "tls")
+				third, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, &second, nil)
+				Expect(generateErr).NotTo(HaveOccurred())
+				Expect(third.InstanceGroups[0].Properties["external_url"]).To(Equal("http://q-s0.web.default-network.some-instance-id.bosh:8080"))
+			})
+
+			It("rejects a tsa tcp route outside gorouter ingress", func() {
+				concoursePlan.Properties["ingress"] = map[string]interface{}{"mode": "none"}
+				concoursePlan.Properties["tsa_tcp_route"] = map[string]interface{}{
+					"router_group":  "default-tcp",
+					"domain":        "tcp.systemdomain.com",
+					"min_port":      1024,
+					"max_port":      1025,
+					"client_id":     "tcp_emitter",
+					"client_secret": "tcp-secret",
+				}
+				_, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).To(MatchError("tsa_tcp_route requires gorouter ingress"))
+			})
+
+			It("rejects load balancer urls shared by all instances", func() {
+				concoursePlan.Properties["ingress"] = map[string]interface{}{
+					"mode":         "load_balancer",
+					"external_url": "https://ci.example.com",
+				}
+				_, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).To(MatchError("ingress.external_url must contain {deployment} to tell instances apart"))
+			})
+
+			It("rejects unknown modes", func() {
+				concoursePlan.Properties["ingress"] = map[string]interface{}{"mode": "nginx"}
+				_, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).To(MatchError(`unknown ingress.mode "nginx", must be gorouter, load_balancer or none`))
+			})
+		})
+
+		Context("cf route", func() {
+			routeRegistrar := func(manifest bosh.BoshManifest) map[string]interface{} {
+				return manifest.InstanceGroups[0].Properties["route_registrar"].(map[string]interface{})
+			}
+
+			It("registers the route as configured by the plan", func() {
+				concoursePlan.Properties["cf_route"] = map[string]interface{}{
+					"name":                  "ci",
+					"port":                  9090,
+					"registration_interval": "10s",
+					"scheme":                "http",
+					"hostname":              "ci-{deployment}",
+					"additional_uris":       []interface{}{"{deployment}.apps.internal"},
+					"router_group":          "isolated",
+				}
+				generated, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).NotTo(HaveOccurred())
+				properties := generated.InstanceGroups[0].Properties
+				Expect(properties["external_url"]).To(Equal("http://ci-some-instance-id.systemdomain.com"))
+				Expect(properties["bind_port"]).To(Equal(9090))
+				Expect(routeRegistrar(generated)["routes"]).To(Equal([]map[string]interface{}{{
+					"name":                  "ci",
+					"port":                  9090,
+					"registration_interval": "10s",
+					"uris":                  []string{"ci-some-instance-id.systemdomain.com", "some-instance-id.apps.internal"},
+					"router_group":          "isolated",
+				}}))
+			})
+
+			It("announces routes on the tls nats of newer cf deployments", func() {
+				concoursePlan.Properties["cf_route"] = map[string]interface{}{
+					"nats": map[string]interface{}{
+						"tls": map[string]interface{}{
+							"client_cert": "((/bosh/cf/nats_client_cert.certificate))",
+							"client_key":  "((/bosh/cf/nats_client_cert.private_key))",
+						},
+					},
+				}
+				generated, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).NotTo(HaveOccurred())
+				Expect(generated.InstanceGroups[0].Jobs[2].Consumes).To(Equal(map[string]interface{}{
+					"nats-tls": bosh.ConsumesLink{From: "nats-tls", Deployment: "cfdeployment"},
+				}))
+				Expect(routeRegistrar(generated)["nats"]).To(Equal(map[string]interface{}{
+					"tls": map[string]interface{}{
+						"enabled":     true,
+						"client_cert": "((/bosh/cf/nats_client_cert.certificate))",
+						"client_key":  "((/bosh/cf/nats_client_cert.private_key))",
+					},
+				}))
+				Expect(generated.InstanceGroups[0].Properties).NotTo(HaveKey("bind_port"))
+			})
+
+			It("consumes nats of the deployment named by the plan", func() {
+				concoursePlan.Properties["cf_route"] = map[string]interface{}{
+					"nats": map[string]interface{}{"deployment": "isolation-segment", "from": "isolated-nats"},
+				}
+				generated, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).NotTo(HaveOccurred())
+				Expect(generated.InstanceGroups[0].Jobs[2].Consumes).To(Equal(map[string]interface{}{
+					"nats": bosh.ConsumesLink{From: "isolated-nats", Deployment: "isolation-segment"},
+				}))
+			})
+
+			It("rejects hostnames shared by all instances", func() {
+				concoursePlan.Properties["cf_route"] = map[string]interface{}{"hostname": "ci"}
+				_, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).To(MatchError(`cf_route uri "ci" must contain {deployment} to tell instances apart`))
+			})
+
+			It("rejects invalid registration intervals", func() {
+				concoursePlan.Properties["cf_route"] = map[string]interface{}{"registration_interval": "often"}
+				_, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).To(MatchError("cf_route.registration_interval must be a duration such as 20s"))
+			})
+		})
+
+		Context("login providers", func() {
+			var releases serviceadapter.ServiceReleases
+
+			BeforeEach(func() {
+				releases = concourse4ServiceReleases()
+				concoursePlan.Properties["auth"] = map[string]interface{}{
+					"oidc": map[string]interface{}{
+						"issuer":        "https://login.example.com",
+						"client_id":     "concourse",
+						"client_secret": "oidc-secret",
+						"groups_key":    "groups",
+					},
+					"github": map[string]interface{}{
+						"client_id":     "github-client",
+						"client_secret": "github-secret",
+					},
+					"ldap": map[string]interface{}{
+						"host":    "ldap.example.com:636",
+						"bind_dn": "cn=admin,dc=example,dc=com",
+						"bind_pw": "ldap-secret",
+						"user_search": map[string]interface{}{
+							"base_dn":  "ou=people,dc=example,dc=com",
+							"username": "uid",
+						},
+					},
+				}
+				defaultRequestParameters["parameters"] = map[string]interface{}{
+					"main_team": map[string]interface{}{
+						"oidc":   map[string]interface{}{"groups": []interface{}{"ci-admins"}},
+						"github": map[string]interface{}{"teams": []interface{}{"acme:platform"}},
+					},
+				}
+			})
+
+			It("configures the connectors offered by the plan", func() {
+				generated, generateErr := generateManifest(manifestGenerator, releases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).NotTo(HaveOccurred())
+				properties := generated.InstanceGroups[0].Properties
+				Expect(properties["generic_oidc"]).To(Equal(map[string]interface{}{
+					"issuer":        "https://login.example.com",
+					"client_id":     "concourse",
+					"client_secret": "oidc-secret",
+					"groups_key":    "groups",
+				}))
+				Expect(properties["github_auth"]).To(HaveKeyWithValue("client_secret", "github-secret"))
+				Expect(properties).NotTo(HaveKey("github"))
+				Expect(properties).NotTo(HaveKey("ldap"))
+				ldap := properties["ldap_auth"].(map[string]interface{})
+				Expect(ldap["bind_pw"]).To(Equal("ldap-secret"))
+				Expect(ldap["user_search"]).To(HaveKeyWithValue("username", "uid"))
+			})
+
+			It("adds the requested members to the main team", func() {
+				generated, generateErr := generateManifest(manifestGenerator, releases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).NotTo(HaveOccurred())
+				mainTeamAuth := generated.InstanceGroups[0].Properties["main_team"].(map[string]interface{})["auth"].(map[string]interface{})
+				Expect(mainTeamAuth["oidc"]).To(Equal(map[string]interface{}{"users": []string{}, "groups": []string{"ci-admins"}}))
+				Expect(mainTeamAuth["github"]).To(HaveKeyWithValue("teams", []string{"acme:platform"}))
+				Expect(mainTeamAuth).NotTo(HaveKey("ldap"))
+				Expect(mainTeamAuth).To(HaveKey("local"))
+			})
+
+			It("keeps the main team members on updates without parameters", func() {
+				first, generateErr := generateManifest(manifestGenerator, releases, concoursePlan, defaultRequestParameters, nil, nil)
+				Expect(generateErr).NotTo(HaveOccurred())
+
+				defaultRequestParameters["parameters"] = map[string]interface{}{}
+				second, generateErr := generateManifest(manifestGenerator, releases, concoursePlan, defaultRequestParameters, &first, nil)
+
+				Expect(generateErr).NotTo(HaveOccurred())
+				Expect(second.InstanceGroups[0].Properties["main_team"]).To(Equal(first.InstanceGroups[0].Properties["main_team"]))
+			})
+
+			It("rejects members of a provider the plan does not offer", func() {
+				delete(concoursePlan.Properties["auth"].(map[string]interface{}), "github")
+				_, generateErr := generateManifest(manifestGenerator, releases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).To(MatchError("main_team.github is not accepted by this plan"))
+			})
+
+			It("rejects github teams without organization", func() {
+				defaultRequestParameters["parameters"] = map[string]interface{}{
+					"main_team": map[string]interface{}{
+						"github": map[string]interface{}{"teams": []interface{}{"platform"}},
+					},
+				}
+				_, generateErr := generateManifest(manifestGenerator, releases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).To(MatchError(ContainSubstring("must be given as org:team")))
+			})
+
+			It("rejects unknown main team settings", func() {
+				defaultRequestParameters["parameters"] = map[string]interface{}{
+					"main_team": map[string]interface{}{
+						"ldap": map[string]interface{}{"admins": []interface{}{"root"}},
+					},
+				}
+				_, generateErr := generateManifest(manifestGenerator, releases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).To(MatchError(ContainSubstring("main_team")))
+			})
+
+			It("does not reveal client secrets in errors", func() {
+				concoursePlan.Properties["auth"].(map[string]interface{})["oidc"].(map[string]interface{})["issuer"] = ""
+				_, generateErr := generateManifest(manifestGenerator, releases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).To(MatchError(ContainSubstring("auth.oidc")))
+				Expect(generateErr.Error()).NotTo(ContainSubstring("oidc-secret"))
+			})
+
+			It("requires concourse 4 or later", func() {
+				_, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, nil, nil)
+
+				Expect(generateErr).To(MatchError(ContainSubstring("require concourse 4 or later")))
+			})
+
+			It("does not hand out client secrets through bindings", func() {
+				generated, generateErr := generateManifest(manifestGenerator, releases, concoursePlan, defaultRequestParameters, nil, nil)
+				Expect(generateErr).NotTo(HaveOccurred())
+
+				binding, bindErr := binder.CreateBinding("some-binding", bosh.BoshVMs{}, generated, nil)
+				Expect(bindErr).NotTo(HaveOccurred())
+				for _, value := range binding.Credentials {
+					Expect(fmt.Sprint(value)).NotTo(Or(ContainSubstring("oidc-secret"), ContainSubstring("github-secret"), ContainSubstring("ldap-secret")))
+				}
+			})
+		})
+
+	})
+
+	Describe("plan schema", func() {
+		var schemaGenerator adapter.SchemaGenerator
+
+		propertyNames := func(schema map[string]interface{}) []string {
+			names := []string{}
+			for name := range schema["properties"].(map[string]interface{}) {
+				names = append(names, name)
+			}
+			return names
+		}
+
+		It("publishes the same parameters for create and update", func() {
+			schema, err := schemaGenerator.GeneratePlanSchema(concoursePlan)
+
+			Expect(err).NotTo(HaveOccurred())
+			create := schema.ServiceInstance.Create.Parameters
+			Expect(create["$schema"]).To(Equal(adapter.JSONSchemaDraft))
+			Expect(create["additionalProperties"]).To(Equal(false))
+			Expect(propertyNames(create)).To(ConsistOf("local_users"))
+			Expect(schema.ServiceInstance.Update.Parameters).To(Equal(create))
+			Expect(propertyNames(schema.ServiceBinding.Create.Parameters)).To(ConsistOf("external_worker"))
+		})
+
+		It("offers the parameters of the errands and login providers of the plan", func() {
+			for _, name := range []string{adapter.TeamsErrandInstanceName, adapter.BootstrapErrandInstanceName} {
+				concoursePlan.InstanceGroups = append(concoursePlan.InstanceGroups, serviceadapter.InstanceGroup{Name: name, Lifecycle: "errand"})
+			}
+			concoursePlan.Properties["auth"] = map[string]interface{}{
+				"oidc": map[string]interface{}{"issuer": "https://login.example.com", "client_id": "concourse", "client_secret": "oidc-secret"},
+			}
+			concoursePlan.Properties["tenant_settings"] = []interface{}{"syslog"}
+			schema, err := schemaGenerator.GeneratePlanSchema(concoursePlan)
+
+			Expect(err).NotTo(HaveOccurred())
+			create := schema.ServiceInstance.Create.Parameters
+			Expect(propertyNames(create)).To(ConsistOf("local_users", "syslog", "main_team", "teams", "bootstrap_pipeline"))
+			mainTeam := create["properties"].(map[string]interface{})["main_team"].(map[string]interface{})
+			Expect(propertyNames(mainTeam)).To(ConsistOf("oidc"))
+			team := create["properties"].(map[string]interface{})["teams"].(map[string]interface{})["items"].(map[string]interface{})
+			Expect(propertyNames(team)).To(ConsistOf("name", "role", "local", "oidc"))
+		})
+
+		It("rejects parameters the plan does not accept", func() {
+			defaultRequestParameters["parameters"] = map[string]interface{}{"app_domain": "example.com"}
+			_, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, nil, nil)
+
+			Expect(generateErr).To(MatchError("app_domain is not accepted by this plan"))
+		})
+
+		It("rejects badly typed parameters", func() {
+			defaultRequestParameters["parameters"] = map[string]interface{}{
+				"local_users": []interface{}{map[string]interface{}{"username": "dev", "password": 42}},
+			}
+			_, generateErr := generateManifest(manifestGenerator, concourse4ServiceReleases(), concoursePlan, defaultRequestParameters, nil, nil)
+
+			Expect(generateErr).To(MatchError("local_users[0].password must be a string"))
+		})
+
+		It("rejects incomplete parameters", func() {
+			defaultRequestParameters["parameters"] = map[string]interface{}{
+				"local_users": []interface{}{map[string]interface{}{"username": "dev"}},
+			}
+			_, generateErr := generateManifest(manifestGenerator, concourse4ServiceReleases(), concoursePlan, defaultRequestParameters, nil, nil)
+
+			Expect(generateErr).To(MatchError("local_users[0].password must be set"))
+		})
+
+		It("rejects unknown binding parameters", func() {
+			generated, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, nil, nil)
+			Expect(generateErr).NotTo(HaveOccurred())
+
+			_, bindErr := binder.CreateBinding("some-binding", bosh.BoshVMs{}, generated, serviceadapter.RequestParameters{
+				"parameters": map[string]interface{}{"worker": true},
+			})
+
+			Expect(bindErr).To(MatchError("worker is not accepted by this plan"))
+		})
+	})
+
+	Describe("dashboard url", func() {
+		var dashboardURLGenerator adapter.DashboardUrlGenerator
+
+		BeforeEach(func() {
+			dashboardURLGenerator = adapter.DashboardUrlGenerator{StderrLogger: stderrLogger}
+		})
+
+		It("links to the routed url of the instance", func() {
+			concoursePlan.Properties["cf_route"] = map[string]interface{}{"hostname": "ci-{deployment}"}
+			generated, generateErr := generateManifest(manifestGenerator, defaultServiceReleases, concoursePlan, defaultRequestParameters, nil, nil)
+			Expect(generateErr).NotTo(HaveOccurred())
+
+			dashboard, err := dashboardURLGenerator.DashboardUrl("some-instance-id", concoursePlan, generated)
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dashboard.DashboardUrl).To(Equal("https://ci-some-instance-id.systemdomain.com"))
+			Expect(dashboard.DashboardUrl).To(Equal(generated.InstanceGroups[0].Properties["external_url"]))
+		})
+
+		It("links to the bosh dns address without ingress", func() {
+			concoursePlan.Properties = map[string]interface{}{
+				"ingress": map[string]interface{}{"mode": "none"},
+				"tls":     map[string]interface{}{"enabled": true},
+			}
+			generated, generateErr := generateManifest(manifestGenerator, concourse4ServiceReleases(), concoursePlan, defaultRequestParameters, nil, nil)
+			Expect(generateErr).NotTo(HaveOccurred())
+
+			dashboard, err := dashboardURLGenerator.DashboardUrl("some-instance-id", concoursePlan, generated)
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dashboard.DashboardUrl).To(Equal("https://q-s0.web.default-network.some-instance-id.bosh:4443"))
+		})
+
+		It("keeps the url instances were deployed with across updates", func() {
+			generated, generateErr := generateManifest(manifestGenerator, concourse4ServiceReleases(), concoursePlan, defaultRequestParameters, nil, nil)
+			Expect(generateErr).NotTo(HaveOccurred())
+			concoursePlan.Properties["app_domain"] = "newdomain.com"
+			updated, generateErr := generateManifest(manifestGenerator, concourse4ServiceReleases(), concoursePlan, defaultRequestParameters, &generated, nil)
+			Expect(generateErr).NotTo(HaveOccurred())
+			updated, generateErr = generateManifest(manifestGenerator, concourse4ServiceReleases(), concoursePlan, defaultRequestParameters, &updated, nil)
+			Expect(generateErr).NotTo(HaveOccurred())
+
+			dashboard, err := dashboardURLGenerator.DashboardUrl("some-instance-id", concoursePlan, updated)
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(updated.InstanceGroups[0].Properties["external_url"]).To(Equal("https://some-instance-id.systemdomain.com"))
+			Expect(dashboard.DashboardUrl).To(Equal("https://some-instance-id.systemdomain.com"))
+		})
+
+		It("fails on manifests without a web instance group", func() {
+			_, err := dashboardURLGenerator.DashboardUrl("some-instance-id", concoursePlan, bosh.BoshManifest{Name: "some-instance-id"})
+
+			Expect(err).To(MatchError("manifest of some-instance-id has no web instance group"))
+		})
+
+		It("fails on manifests without an external_url", func() {
+			manifest := bosh.BoshManifest{Name: "some-instance-id", InstanceGroups: []bosh.InstanceGroup{
+				{Name: adapter.WebInstanceName, Properties: map[string]interface{}{}},
+			}}
+			_, err := dashboardURLGenerator.DashboardUrl("some-instance-id", concoursePlan, manifest)
+
+			Expect(err).To(MatchError("manifest of some-instance-id has no external_url"))
+		})
+	})
+
+	Describe("render", func() {
+		var brokerConfig adapter.BrokerConfig
+
+		BeforeEach(func() {
+			var err error
+			brokerConfig, err = adapter.LoadBrokerConfig(getFixturePath("broker-config.yml"))
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("renders the plan of the broker config as the broker would deploy it", func() {
+			manifest, err := adapter.Render(brokerConfig, adapter.RenderOptions{PlanName: "small", InstanceID: "some-instance-id"}, getFixturePath("concourse-service-adapter.conf"), stderrLogger)
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(manifest.Name).To(Equal("service-instance_some-instance-id"))
+			Expect(manifest.Stemcells[0].OS).To(Equal("ubuntu-trusty"))
+			Expect(manifest.Stemcells[0].Version).To(Equal("3421.10"))
+			Expect(manifest.InstanceGroups).To(HaveLen(3))
+			Expect(manifest.InstanceGroups[2].Instances).To(Equal(2))
+			Expect(manifest.InstanceGroups[0].Properties["external_url"]).To(Equal("https://service-instance_some-instance-id.ci.example.com"))
+			Expect(manifest.InstanceGroups[0].Jobs[2].Consumes["nats"]).To(Equal(bosh.ConsumesLink{From: "nats", Deployment: "cf"}))
+			Expect(manifest.Update.MaxInFlight).To(Equal(2))
+		})
+
+		It("renders updates with the secrets of the previous manifest", func() {
+			options := adapter.RenderOptions{PlanName: "small", InstanceID: "some-instance-id"}
+			previous, err := adapter.Render(brokerConfig, options, "", stderrLogger)
+			Expect(err).NotTo(HaveOccurred())
+
+			options.PreviousManifest = &previous
+			options.PreviousPlanName = "small"
+			manifest, err := adapter.Render(brokerConfig, options, "", stderrLogger)
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(manifest.InstanceGroups[0].Properties["basic_auth_password"]).To(Equal(previous.InstanceGroups[0].Properties["basic_auth_password"]))
+		})
+
+		It("rejects plans the broker config does not define", func() {
+			_, err := adapter.Render(brokerConfig, adapter.RenderOptions{PlanName: "large"}, "", stderrLogger)
+
+			Expect(err).To(MatchError("broker config has no plan large"))
+		})
+	})
+
+	Describe("binding", func() {
+		var (
+			actualBinding    serviceadapter.Binding
+			actualBindingErr error
+			boshVMs          bosh.BoshVMs
+			currentManifest  bosh.BoshManifest
+		)
+		BeforeEach(func() {
+
+			boshVMs = bosh.BoshVMs{}
+			currentManifest = bosh.BoshManifest{
+				InstanceGroups: []bosh.InstanceGroup{
+					{
+						Properties: map[string]interface{}{
+							"basic_auth_username": "atc",
+							"basic_auth_password": "password",
+							"external_url":        "host",
+						},
+					},
+				},
+			}
+
+		})
+
+		JustBeforeEach(func() {
+			actualBinding, actualBindingErr = binder.CreateBinding("not-relevant", boshVMs, currentManifest, nil)
+		})
+
+		Context("has a password in the manifest", func() {
+			It("has no error", func() {
+				Expect(actualBindingErr).NotTo(HaveOccurred())
+			})
+
+			It("returns the username from the manifest", func() {
+				Expect(actualBinding.Credentials["username"]).To(Equal("atc"))
+			})
+
+			It("returns the password from the manifest", func() {
+				Expect(actualBinding.Credentials["password"]).To(Equal("password"))
+			})
+
+			It("returns the host from the vms", func() {
+				Expect(actualBinding.Credentials["host"]).To(Equal("host"))
+			})
+		})
+
+		Context("has a credhub placeholder in the manifest", func() {
+			var resolver *fakeSecretResolver
+
+			BeforeEach(func() {
+				currentManifest.InstanceGroups[0].Properties["basic_auth_password"] = "((/concourse/some-instance-id/basic_auth_password))"
+				resolver = &fakeSecretResolver{values: map[string]interface{}{
+					"/concourse/some-instance-id/basic_auth_password": "password-from-credhub",
+				}}
+				binder.ConfigPath = getFixturePath("director-credhub.conf")
+				binder.SecretResolver = resolver
+			})
+
+			It("returns the password read from the credhub of the director", func() {
+				Expect(actualBindingErr).NotTo(HaveOccurred())
+				Expect(actualBinding.Credentials["password"]).To(Equal("password-from-credhub"))
+				Expect(resolver.server.URL).To(Equal("https://credhub.service.internal:8844"))
+				Expect(resolver.server.ClientID).To(Equal("service-adapter"))
+			})
+
+			Context("without director_credhub in the adapter config", func() {
+				BeforeEach(func() {
+					binder.ConfigPath = getFixturePath("service-adapter-defaults.conf")
+				})
+
+				It("fails the binding", func() {
+					Expect(actualBindingErr).To(MatchError("binding instances of credhub plans requires director_credhub in the adapter config"))
+				})
+			})
+		})
+	})
+
+})
+
+var keyPairPool []adapter.KeyPair
+
+//pooledKeyPairGenerator hands out distinct real keys, generated once for the whole suite since rsa key generation is slow
+func pooledKeyPairGenerator(generator func() (adapter.KeyPair, error)) func() (adapter.KeyPair, error) {
+	next := 0
+	return func() (adapter.KeyPair, error) {
+		if next == len(keyPairPool) {
+			keyPair, err := generator()
+			if err != nil {
+				return keyPair, err
+			}
+			keyPairPool = append(keyPairPool, keyPair)
+		}
+		next++
+		return keyPairPool[next-1], nil
+	}
+}
+
+type fakeDatabaseProvisioner struct {
+	server   adapter.ExternalDatabase
+	database string
+	role     string
+	password string
+	err      error
+}
+
+func (f *fakeDatabaseProvisioner) EnsureDatabase(server adapter.ExternalDatabase, database string, role string, password string) error {
+	f.server, f.database, f.role, f.password = server, database, role, password
+	return f.err
+}
+
+type fakeSecretResolver struct {
+	server adapter.CredhubConfig
+	values map[string]interface{}
+}
+
+func (f *fakeSecretResolver) Resolve(server adapter.CredhubConfig, name string) (interface{}, error) {
+	f.server = server
+	value, found := f.values[name]
+	if !found {
+		return nil, fmt.Errorf("%s not found", name)
+	}
+	return value, nil
+}
+
+type fakeSecretStore struct {
+	server adapter.CredhubConfig
+	stored map[string]interface{}
+	types  map[string]string
+}
+
+func (f *fakeSecretStore) Store(server adapter.CredhubConfig, name string, variableType string, value interface{}) error {
+	if f.stored == nil {
+		f.stored, f.types = map[string]interface{}{}, map[string]string{}
+	}
+	f.server, f.stored[name], f.types[name] = server, value, variableType
+	return nil
+}
+
+type fakeClientRegistrar struct {
+	server adapter.UAAConfig
+	client adapter.UAAClient
+	err    error
+}
+
+func (f *fakeClientRegistrar) EnsureClient(server adapter.UAAConfig, client adapter.UAAClient) error {
+	f.server, f.client = server, client
+	return f.err
+}
+
+func concourse4ServiceReleases() serviceadapter.ServiceReleases {
+	return serviceadapter.ServiceReleases{
+		{Name: adapter.ConcourseReleaseName, Version: "5", Jobs: []string{adapter.WebJobName, adapter.WorkerJobName}},
+		{Name: adapter.BpmReleaseName, Version: "1", Jobs: []string{adapter.BpmJobName}},
+		{Name: adapter.PostgresReleaseName, Version: "38", Jobs: []string{adapter.PostgresServerJobName}},
+		{Name: adapter.RoutingReleaseName, Version: "9", Jobs: []string{adapter.RouteRegisterJobName}},
+	}
+}
+
+func createManifestGenerator(filename string, logger *log.Logger) adapter.ManifestGenerator {
+	return adapter.ManifestGenerator{
+		StderrLogger: logger,
+		ConfigPath:   getFixturePath(filename),
+	}
+}
+
+func createDefaultEmptyManifest() bosh.BoshManifest {
+	return bosh.BoshManifest{}
+}
+
+func createDefaultOldManifest() bosh.BoshManifest {
+	return bosh.BoshManifest{
+		Releases: []bosh.Release{
+			{Name: "some-release-name", Version: "4"},
+		},
+		InstanceGroups: []bosh.InstanceGroup{},
+	}
+}
+
+//testCertificateAuthority PEM encoded certificate and key of a throwaway CA
+func testCertificateAuthority() (string, string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	Expect(err).NotTo(HaveOccurred())
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "operator CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	Expect(err).NotTo(HaveOccurred())
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}))
+}
+
+func parseTestCertificate(value interface{}) *x509.Certificate {
+	block, _ := pem.Decode([]byte(value.(string)))
+	Expect(block).NotTo(BeNil())
+	certificate, err := x509.ParseCertificate(block.Bytes)
+	Expect(err).NotTo(HaveOccurred())
+	return certificate
 }
 
 func getFixturePath(filename string) string {
@@ -0,0 +1,95 @@
+package adapter
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pivotal-cf/on-demand-services-sdk/serviceadapter"
+)
+
+const (
+	//CredhubCredentialManager pipeline secrets are looked up in credhub
+	CredhubCredentialManager = "credhub"
+	//VaultCredentialManager pipeline secrets are looked up in vault
+	VaultCredentialManager = "vault"
+	//DefaultCredentialManagerPathPrefix path prefix of pipeline secrets when the plan does not set one
+	DefaultCredentialManagerPathPrefix = "/concourse"
+)
+
+//CredentialManager credential_manager plan property. For vault, client_id and client_secret are the
+//role_id and secret_id of an approle, a client_token can be given instead
+type CredentialManager struct {
+	Type         string `json:"type"`
+	URL          string `json:"url"`
+	CACert       string `json:"ca_cert"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	ClientToken  string `json:"client_token"`
+	PathPrefix   string `json:"path_prefix"`
+}
+
+func parseCredentialManager(planProperties serviceadapter.Properties) (*CredentialManager, error) {
+	manager := &CredentialManager{}
+	found, err := decodePlanProperty(planProperties, "credential_manager", manager)
+	if err != nil || !found {
+		return nil, err
+	}
+	if manager.Type != CredhubCredentialManager && manager.Type != VaultCredentialManager {
+		return nil, fmt.Errorf("credential_manager.type must be %s or %s", CredhubCredentialManager, VaultCredentialManager)
+	}
+	if manager.URL == "" {
+		return nil, fmt.Errorf("credential_manager.url must be set")
+	}
+	hasClient := manager.ClientID != "" && manager.ClientSecret != ""
+	if manager.Type == CredhubCredentialManager && !hasClient {
+		return nil, fmt.Errorf("credential_manager.client_id and credential_manager.client_secret must be set")
+	}
+	if manager.Type == VaultCredentialManager && !hasClient && manager.ClientToken == "" {
+		return nil, fmt.Errorf("credential_manager.client_id and credential_manager.client_secret or credential_manager.client_token must be set")
+	}
+	if manager.PathPrefix == "" {
+		manager.PathPrefix = DefaultCredentialManagerPathPrefix
+	}
+	if !strings.HasPrefix(manager.PathPrefix, "/") {
+		return nil, fmt.Errorf("credential_manager.path_prefix must be an absolute path")
+	}
+	return manager, nil
+}
+
+//instancePathPrefix atc looks secrets up below <path_prefix>/<deployment>/<team>, so pipelines of one
+//service instance never resolve secrets of another. The client given in the plan has to be restricted by
+//the operator accordingly, it is shared by all instances of the plan
+func (c *CredentialManager) instancePathPrefix(deploymentName string) string {
+	return fmt.Sprintf("%s/%s", strings.TrimSuffix(c.PathPrefix, "/"), deploymentName)
+}
+
+//credentialManagerProperties atc settings of the credential manager, the same on all concourse generations
+func credentialManagerProperties(manager *CredentialManager, deploymentName string) (string, map[string]interface{}) {
+	tls := map[string]interface{}{}
+	if manager.CACert != "" {
+		tls["ca_cert"] = map[string]interface{}{"certificate": manager.CACert}
+	}
+	properties := map[string]interface{}{
+		"url":         manager.URL,
+		"path_prefix": manager.instancePathPrefix(deploymentName),
+		"tls":         tls,
+	}
+	if manager.Type == CredhubCredentialManager {
+		properties["client_id"] = manager.ClientID
+		properties["client_secret"] = manager.ClientSecret
+		return CredhubCredentialManager, properties
+	}
+
+	auth := map[string]interface{}{}
+	if manager.ClientToken != "" {
+		auth["client_token"] = manager.ClientToken
+	} else {
+		auth["backend"] = "approle"
+		auth["params"] = map[string]interface{}{
+			"role_id":   manager.ClientID,
+			"secret_id": manager.ClientSecret,
+		}
+	}
+	properties["auth"] = auth
+	return VaultCredentialManager, properties
+}
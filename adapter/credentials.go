@@ -0,0 +1,218 @@
+package adapter
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pivotal-cf/on-demand-services-sdk/bosh"
+	"github.com/pivotal-cf/on-demand-services-sdk/serviceadapter"
+)
+
+const (
+	//ManifestCredentialStorage generated secrets are written into the manifest
+	ManifestCredentialStorage = "manifest"
+	//CredhubCredentialStorage secrets are declared as bosh variables and resolved by the director through credhub
+	CredhubCredentialStorage = "credhub"
+	//DefaultCredhubPathPrefix prefix of the variable names when credhub_path_prefix is not set on the plan
+	DefaultCredhubPathPrefix = "/concourse"
+
+	//PasswordVariableType bosh variable type of passwords
+	PasswordVariableType = "password"
+	//CertificateVariableType bosh variable type of certificates
+	CertificateVariableType = "certificate"
+	//SSHVariableType bosh variable type of ssh keypairs
+	SSHVariableType = "ssh"
+	//RSAVariableType bosh variable type of rsa keypairs
+	RSAVariableType = "rsa"
+	//ValueVariableType credhub type of arbitrary strings the adapter stores
+	ValueVariableType = "value"
+	//JSONVariableType credhub type of structured values the adapter stores
+	JSONVariableType = "json"
+)
+
+//credentials hands out secrets for the manifest. Depending on the plan they are literals,
+//kept stable through the previous manifest, or placeholders of bosh variables stored in credhub
+type credentials struct {
+	storage          string
+	pathPrefix       string
+	previousManifest *bosh.BoshManifest
+	variables        []bosh.Variable
+	//stored secrets of the tenant the adapter writes into credhub before deploying, credhub plans only
+	stored []storedSecret
+}
+
+type storedSecret struct {
+	name         string
+	variableType string
+	value        interface{}
+}
+
+//parseCredentialStorage credential_storage and credhub_path_prefix plan properties
+func parseCredentialStorage(planProperties serviceadapter.Properties) (storage string, pathPrefix string, err error) {
+	storage = ManifestCredentialStorage
+	if value, ok := planProperties["credential_storage"]; ok {
+		storage, ok = value.(string)
+		if !ok {
+			return "", "", fmt.Errorf("credential_storage must be a string")
+		}
+	}
+	if storage != ManifestCredentialStorage && storage != CredhubCredentialStorage {
+		return "", "", fmt.Errorf("unknown credential_storage %q, must be %s or %s", storage, ManifestCredentialStorage, CredhubCredentialStorage)
+	}
+
+	pathPrefix = DefaultCredhubPathPrefix
+	if value, ok := planProperties["credhub_path_prefix"]; ok {
+		pathPrefix, ok = value.(string)
+		if !ok || !strings.HasPrefix(pathPrefix, "/") {
+			return "", "", fmt.Errorf("credhub_path_prefix must be an absolute path")
+		}
+	}
+	return storage, pathPrefix, nil
+}
+
+func newCredentials(deploymentName string, storage string, pathPrefix string, previousManifest *bosh.BoshManifest) *credentials {
+	return &credentials{
+		storage:          storage,
+		pathPrefix:       fmt.Sprintf("%s/%s", strings.TrimSuffix(pathPrefix, "/"), deploymentName),
+		previousManifest: previousManifest,
+	}
+}
+
+func (c *credentials) useCredhub() bool {
+	return c.storage == CredhubCredentialStorage
+}
+
+//declare adds a bosh variable, the name is absolute so the binder can read it from the credhub of the director
+func (c *credentials) declare(name string, variableType string, options map[string]interface{}) string {
+	variableName := fmt.Sprintf("%s/%s", c.pathPrefix, name)
+	for _, variable := range c.variables {
+		if variable.Name == variableName {
+			return variableName
+		}
+	}
+	c.variables = append(c.variables, bosh.Variable{
+		Name:    variableName,
+		Type:    variableType,
+		Options: options,
+	})
+	return variableName
+}
+
+//password returns a credhub placeholder or the password found at the path of the previous manifest, a new one on fresh deployments
+func (c *credentials) password(name string, instanceGroupName string, path ...interface{}) (string, error) {
+	previous, found := previousStringProperty(c.previousManifest, instanceGroupName, path...)
+	return c.passwordWithPrevious(name, previous, found)
+}
+
+//passwordWithPrevious for passwords the caller has to dig out of the previous manifest itself
+func (c *credentials) passwordWithPrevious(name string, previous string, found bool) (string, error) {
+	if c.useCredhub() {
+		return placeholder(c.declare(name, PasswordVariableType, nil)), nil
+	}
+	if found {
+		return previous, nil
+	}
+	return CurrentPasswordGenerator()
+}
+
+//sshKey private key and ssh formatted public key, reused from the path of the previous manifest
+func (c *credentials) sshKey(name string, instanceGroupName string, path ...interface{}) (map[string]interface{}, error) {
+	return c.keyPair(name, SSHVariableType, instanceGroupName, path...)
+}
+
+//rsaKey PEM encoded private and public key, reused from the path of the previous manifest
+func (c *credentials) rsaKey(name string, instanceGroupName string, path ...interface{}) (map[string]interface{}, error) {
+	return c.keyPair(name, RSAVariableType, instanceGroupName, path...)
+}
+
+func (c *credentials) keyPair(name string, variableType string, instanceGroupName string, path ...interface{}) (map[string]interface{}, error) {
+	if c.useCredhub() {
+		variableName := c.declare(name, variableType, nil)
+		return map[string]interface{}{
+			"private_key": placeholder(variableName + ".private_key"),
+			"public_key":  placeholder(variableName + ".public_key"),
+		}, nil
+	}
+
+	privateKey, privateFound := previousStringProperty(c.previousManifest, instanceGroupName, propertyPath(path, "private_key")...)
+	publicKey, publicFound := previousStringProperty(c.previousManifest, instanceGroupName, propertyPath(path, "public_key")...)
+	if privateFound && publicFound {
+		return map[string]interface{}{
+			"private_key": privateKey,
+			"public_key":  publicKey,
+		}, nil
+	}
+
+	generated, err := CurrentKeyPairGenerator()
+	if err != nil {
+		return nil, err
+	}
+	publicKey = generated.PublicKey
+	if variableType == SSHVariableType {
+		publicKey = generated.SSHPublicKey
+	}
+	return map[string]interface{}{
+		"private_key": generated.PrivateKey,
+		"public_key":  publicKey,
+	}, nil
+}
+
+//sshKeyFromPrivateKey for properties holding only the private key, the public key is derived from the previous private key
+func (c *credentials) sshKeyFromPrivateKey(name string, instanceGroupName string, path ...interface{}) (map[string]interface{}, error) {
+	if c.useCredhub() {
+		return c.keyPair(name, SSHVariableType, instanceGroupName, path...)
+	}
+	if privateKey, found := previousStringProperty(c.previousManifest, instanceGroupName, path...); found {
+		if previous, err := keyPairFromPrivateKey(privateKey); err == nil {
+			return map[string]interface{}{
+				"private_key": previous.PrivateKey,
+				"public_key":  previous.SSHPublicKey,
+			}, nil
+		}
+	}
+	generated, err := CurrentKeyPairGenerator()
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"private_key": generated.PrivateKey,
+		"public_key":  generated.SSHPublicKey,
+	}, nil
+}
+
+//store secrets chosen by the tenant are written into credhub by the adapter on credhub plans,
+//the placeholder of the variable replaces them. Other plans keep the value
+func (c *credentials) store(name string, variableType string, value interface{}) interface{} {
+	if !c.useCredhub() {
+		return value
+	}
+	variableName := fmt.Sprintf("%s/%s", c.pathPrefix, name)
+	c.stored = append(c.stored, storedSecret{name: variableName, variableType: variableType, value: value})
+	return placeholder(variableName)
+}
+
+//localUserPasswords passwords of local users, stored under <prefix>/<deployment>/local_users/<username> on credhub plans
+func (c *credentials) localUserPasswords(users []LocalUser) []LocalUser {
+	if users == nil {
+		return nil
+	}
+	stored := []LocalUser{}
+	for _, user := range users {
+		password := c.store("local_users/"+user.Username, PasswordVariableType, user.Password)
+		stored = append(stored, LocalUser{Username: user.Username, Password: password.(string)})
+	}
+	return stored
+}
+
+func placeholder(variableName string) string {
+	return fmt.Sprintf("((%s))", variableName)
+}
+
+//credhubReference the variable name behind a ((placeholder)) property
+func credhubReference(value interface{}) (string, bool) {
+	str, ok := value.(string)
+	if !ok || !strings.HasPrefix(str, "((") || !strings.HasSuffix(str, "))") {
+		return "", false
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(str, "(("), "))"), true
+}
@@ -0,0 +1,193 @@
+package adapter
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+//CredhubConfig director_credhub setting of the adapter config, the credhub the director stores the variables of
+//service instances in. The client needs read access below the credhub_path_prefix of the plans and write access
+//below /concourse-service-adapter, where the keys of external workers are kept
+type CredhubConfig struct {
+	URL               string `json:"url"`
+	UAAURL            string `json:"uaa_url"`
+	ClientID          string `json:"client_id"`
+	ClientSecret      string `json:"client_secret"`
+	CACert            string `json:"ca_cert"`
+	SkipSSLValidation bool   `json:"skip_ssl_validation"`
+}
+
+//SecretResolver reads the current value of a bosh variable, applications cannot reach the credhub of the director
+//so bindings carry the resolved values
+type SecretResolver interface {
+	Resolve(server CredhubConfig, name string) (interface{}, error)
+}
+
+//SecretStore writes secrets tenants chose into the credhub of the director, the manifest only references them
+type SecretStore interface {
+	Store(server CredhubConfig, name string, variableType string, value interface{}) error
+}
+
+func parseCredhubConfig(settings map[string]interface{}) (*CredhubConfig, error) {
+	config := &CredhubConfig{}
+	found, err := decodePlanProperty(settings, "director_credhub", config)
+	if err != nil || !found {
+		return nil, err
+	}
+	if config.URL == "" || config.UAAURL == "" {
+		return nil, fmt.Errorf("director_credhub.url and director_credhub.uaa_url must be set")
+	}
+	if config.ClientID == "" || config.ClientSecret == "" {
+		return nil, fmt.Errorf("director_credhub.client_id and director_credhub.client_secret must be set")
+	}
+	config.URL = strings.TrimSuffix(config.URL, "/")
+	config.UAAURL = strings.TrimSuffix(config.UAAURL, "/")
+	return config, nil
+}
+
+//CredhubClient reads and writes variables through the credhub api
+type CredhubClient struct{}
+
+//Resolve the value of the variable, a single field of it for names like /path/tsa_host_key.public_key
+func (c CredhubClient) Resolve(server CredhubConfig, name string) (interface{}, error) {
+	httpClient, token, err := c.login(server)
+	if err != nil {
+		return nil, err
+	}
+
+	variableName, field := splitVariableField(name)
+	request, err := http.NewRequest("GET", fmt.Sprintf("%s/api/v1/data?current=true&name=%s", server.URL, url.QueryEscape(variableName)), nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Authorization", "Bearer "+token)
+	request.Header.Set("Accept", "application/json")
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("reading credhub variable %s: unexpected status %d", variableName, response.StatusCode)
+	}
+	var data struct {
+		Data []struct {
+			Value interface{} `json:"value"`
+		} `json:"data"`
+	}
+	if err = json.NewDecoder(response.Body).Decode(&data); err != nil {
+		return nil, err
+	}
+	if len(data.Data) == 0 {
+		return nil, fmt.Errorf("credhub variable %s has no value", variableName)
+	}
+	value := data.Data[0].Value
+	if field == "" {
+		return value, nil
+	}
+	fields, ok := value.(map[string]interface{})
+	if !ok || fields[field] == nil {
+		return nil, fmt.Errorf("credhub variable %s has no %s", variableName, field)
+	}
+	return fields[field], nil
+}
+
+//Store sets the variable, credhub keeps the previous values as versions
+func (c CredhubClient) Store(server CredhubConfig, name string, variableType string, value interface{}) error {
+	httpClient, token, err := c.login(server)
+	if err != nil {
+		return err
+	}
+	status, err := UAAClientRegistrar{}.do(httpClient, token, "PUT", server.URL+"/api/v1/data", map[string]interface{}{
+		"name":  name,
+		"type":  variableType,
+		"value": value,
+	})
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("storing credhub variable %s: unexpected status %d", name, status)
+	}
+	return nil
+}
+
+//Delete the variable with all its versions, missing variables are ignored
+func (c CredhubClient) Delete(server CredhubConfig, name string) error {
+	httpClient, token, err := c.login(server)
+	if err != nil {
+		return err
+	}
+	status, err := UAAClientRegistrar{}.do(httpClient, token, "DELETE", fmt.Sprintf("%s/api/v1/data?name=%s", server.URL, url.QueryEscape(name)), nil)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusNoContent && status != http.StatusNotFound {
+		return fmt.Errorf("deleting credhub variable %s: unexpected status %d", name, status)
+	}
+	return nil
+}
+
+//FindNames names of the variables below path
+func (c CredhubClient) FindNames(server CredhubConfig, path string) ([]string, error) {
+	httpClient, token, err := c.login(server)
+	if err != nil {
+		return nil, err
+	}
+	request, err := http.NewRequest("GET", fmt.Sprintf("%s/api/v1/data?path=%s", server.URL, url.QueryEscape(path)), nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Authorization", "Bearer "+token)
+	request.Header.Set("Accept", "application/json")
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("finding credhub variables below %s: unexpected status %d", path, response.StatusCode)
+	}
+	var found struct {
+		Credentials []struct {
+			Name string `json:"name"`
+		} `json:"credentials"`
+	}
+	if err = json.NewDecoder(response.Body).Decode(&found); err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, credential := range found.Credentials {
+		names = append(names, credential.Name)
+	}
+	return names, nil
+}
+
+//login the uaa of the credhub issues the token, the same way it does for the admin client of uaa logins
+func (c CredhubClient) login(server CredhubConfig) (*http.Client, string, error) {
+	uaa := UAAConfig{
+		URL:               server.UAAURL,
+		AdminClientID:     server.ClientID,
+		AdminClientSecret: server.ClientSecret,
+		CACert:            server.CACert,
+		SkipSSLValidation: server.SkipSSLValidation,
+	}
+	httpClient, err := UAAClientRegistrar{}.httpClient(uaa)
+	if err != nil {
+		return nil, "", err
+	}
+	token, err := UAAClientRegistrar{}.token(httpClient, uaa)
+	return httpClient, token, err
+}
+
+//splitVariableField variable names are paths, a dot in the last element selects a field of the value
+func splitVariableField(name string) (string, string) {
+	dot := strings.LastIndex(name, ".")
+	if dot <= strings.LastIndex(name, "/") {
+		return name, ""
+	}
+	return name[:dot], name[dot+1:]
+}
@@ -0,0 +1,176 @@
+package adapter_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+
+	"github.com/datianshi/concourse-service-adapter/adapter"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Credhub client", func() {
+	var (
+		server    *httptest.Server
+		client    adapter.CredhubClient
+		config    adapter.CredhubConfig
+		variables map[string]interface{}
+	)
+
+	BeforeEach(func() {
+		variables = map[string]interface{}{
+			"/concourse/some-instance-id/basic_auth_password": "password-from-credhub",
+			"/concourse/some-instance-id/tsa_host_key": map[string]interface{}{
+				"private_key": "private",
+				"public_key":  "ssh-rsa public",
+			},
+		}
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/oauth/token" {
+				if id, secret, ok := r.BasicAuth(); !ok || id != "service-adapter" || secret != "service-adapter-secret" {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				json.NewEncoder(w).Encode(map[string]string{"access_token": "adapter-token"})
+				return
+			}
+			if r.Header.Get("Authorization") != "Bearer adapter-token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if r.Method == "PUT" && r.URL.Path == "/api/v1/data" {
+				var variable map[string]interface{}
+				json.NewDecoder(r.Body).Decode(&variable)
+				if variable["type"] != "password" && variable["type"] != "json" && variable["type"] != "value" {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				variables[variable["name"].(string)] = variable["value"]
+				json.NewEncoder(w).Encode(variable)
+				return
+			}
+			if r.Method == "DELETE" && r.URL.Path == "/api/v1/data" {
+				if _, found := variables[r.URL.Query().Get("name")]; !found {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				delete(variables, r.URL.Query().Get("name"))
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			if path := r.URL.Query().Get("path"); path != "" {
+				credentials := []map[string]interface{}{}
+				for name := range variables {
+					if strings.HasPrefix(name, strings.TrimSuffix(path, "/")+"/") {
+						credentials = append(credentials, map[string]interface{}{"name": name})
+					}
+				}
+				json.NewEncoder(w).Encode(map[string]interface{}{"credentials": credentials})
+				return
+			}
+			value, found := variables[r.URL.Query().Get("name")]
+			if r.URL.Path != "/api/v1/data" || !found {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": []map[string]interface{}{{"value": value}}})
+		}))
+		config = adapter.CredhubConfig{
+			URL:          server.URL,
+			UAAURL:       server.URL,
+			ClientID:     "service-adapter",
+			ClientSecret: "service-adapter-secret",
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("reads the value of a variable", func() {
+		Expect(client.Resolve(config, "/concourse/some-instance-id/basic_auth_password")).To(Equal("password-from-credhub"))
+	})
+
+	It("reads a field of a variable", func() {
+		Expect(client.Resolve(config, "/concourse/some-instance-id/tsa_host_key.public_key")).To(Equal("ssh-rsa public"))
+	})
+
+	It("stores variables", func() {
+		Expect(client.Store(config, "/concourse/some-instance-id/local_users/alice", "password", "alice-password")).To(Succeed())
+		Expect(client.Store(config, "/concourse/some-instance-id/bootstrap_pipeline/vars", "json", map[string]interface{}{"environment": "dev"})).To(Succeed())
+
+		Expect(client.Resolve(config, "/concourse/some-instance-id/local_users/alice")).To(Equal("alice-password"))
+		Expect(client.Resolve(config, "/concourse/some-instance-id/bootstrap_pipeline/vars")).To(Equal(map[string]interface{}{"environment": "dev"}))
+	})
+
+	It("fails for unknown variables", func() {
+		_, err := client.Resolve(config, "/concourse/some-instance-id/missing")
+
+		Expect(err).To(MatchError(ContainSubstring("unexpected status 404")))
+	})
+
+	It("deletes variables", func() {
+		Expect(client.Delete(config, "/concourse/some-instance-id/basic_auth_password")).To(Succeed())
+		Expect(client.Delete(config, "/concourse/some-instance-id/basic_auth_password")).To(Succeed())
+
+		Expect(variables).NotTo(HaveKey("/concourse/some-instance-id/basic_auth_password"))
+	})
+
+	It("finds the variables below a path", func() {
+		Expect(client.FindNames(config, "/concourse/some-instance-id")).To(ConsistOf(
+			"/concourse/some-instance-id/basic_auth_password",
+			"/concourse/some-instance-id/tsa_host_key",
+		))
+	})
+
+	Context("worker keys", func() {
+		var (
+			configPath string
+			keyStore   adapter.CredhubWorkerKeyStore
+		)
+
+		BeforeEach(func() {
+			configFile, err := ioutil.TempFile("", "service-adapter.conf")
+			Expect(err).NotTo(HaveOccurred())
+			configPath = configFile.Name()
+			fmt.Fprintf(configFile, "director_credhub:\n  url: %s\n  uaa_url: %s\n  client_id: service-adapter\n  client_secret: service-adapter-secret\n", server.URL, server.URL)
+			configFile.Close()
+			keyStore = adapter.CredhubWorkerKeyStore{StderrLogger: log.New(GinkgoWriter, "", 0), ConfigPath: configPath, Client: client}
+		})
+
+		AfterEach(func() {
+			os.Remove(configPath)
+		})
+
+		It("keeps the keys of a deployment in credhub", func() {
+			Expect(keyStore.Add("some-instance-id", "binding-b", "ssh-rsa key-b")).To(Succeed())
+			Expect(keyStore.Add("some-instance-id", "binding-a", "ssh-rsa key-a")).To(Succeed())
+			Expect(keyStore.Add("other-instance-id", "binding-c", "ssh-rsa key-c")).To(Succeed())
+
+			Expect(variables).To(HaveKeyWithValue("/concourse-service-adapter/worker_keys/some-instance-id/binding-a", "ssh-rsa key-a"))
+			Expect(keyStore.List("some-instance-id")).To(Equal([]string{"ssh-rsa key-a", "ssh-rsa key-b"}))
+		})
+
+		It("removes the key of a binding", func() {
+			Expect(keyStore.Add("some-instance-id", "binding-a", "ssh-rsa key-a")).To(Succeed())
+			Expect(keyStore.Remove("some-instance-id", "binding-a")).To(Succeed())
+			Expect(keyStore.Remove("some-instance-id", "binding-without-key")).To(Succeed())
+
+			Expect(keyStore.List("some-instance-id")).To(BeEmpty())
+		})
+
+		It("requires director_credhub to add keys", func() {
+			keyStore.ConfigPath = ""
+
+			Expect(keyStore.Add("some-instance-id", "binding-a", "ssh-rsa key-a")).To(MatchError(ContainSubstring("external workers require director_credhub")))
+			Expect(keyStore.List("some-instance-id")).To(BeEmpty())
+		})
+	})
+})
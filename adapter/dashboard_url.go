@@ -0,0 +1,31 @@
+package adapter
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/pivotal-cf/on-demand-services-sdk/bosh"
+	"github.com/pivotal-cf/on-demand-services-sdk/serviceadapter"
+)
+
+//DashboardUrlGenerator links cf service to the concourse ui of the instance
+type DashboardUrlGenerator struct {
+	StderrLogger *log.Logger
+}
+
+//DashboardUrl Contract of the broker on provision and update, the external_url atc was deployed with.
+//The manifest generator already applied the ingress of the plan and kept the url of migrated deployments
+func (d DashboardUrlGenerator) DashboardUrl(instanceID string, plan serviceadapter.Plan, manifest bosh.BoshManifest) (serviceadapter.DashboardUrl, error) {
+	if findPreviousInstanceGroup(&manifest, WebInstanceName) == nil {
+		err := fmt.Errorf("manifest of %s has no %s instance group", instanceID, WebInstanceName)
+		d.StderrLogger.Println(err)
+		return serviceadapter.DashboardUrl{}, err
+	}
+	externalURL, found := previousStringProperty(&manifest, WebInstanceName, "external_url")
+	if !found {
+		err := fmt.Errorf("manifest of %s has no external_url", instanceID)
+		d.StderrLogger.Println(err)
+		return serviceadapter.DashboardUrl{}, err
+	}
+	return serviceadapter.DashboardUrl{DashboardUrl: externalURL}, nil
+}
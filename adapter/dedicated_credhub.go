@@ -0,0 +1,237 @@
+package adapter
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+
+	"github.com/pivotal-cf/on-demand-services-sdk/bosh"
+	"github.com/pivotal-cf/on-demand-services-sdk/serviceadapter"
+)
+
+const (
+	//CredhubReleaseName name of the credhub release
+	CredhubReleaseName = "credhub"
+	//CredhubJobName credhub job name
+	CredhubJobName = "credhub"
+	//CredhubInstanceName instance group of a dedicated credhub that is not colocated on the db instance group
+	CredhubInstanceName = "credhub"
+	//CredhubPort port credhub serves its api on
+	CredhubPort = 8844
+	//CredhubDatabaseName database and role of credhub on the db instance group
+	CredhubDatabaseName = "credhub"
+)
+
+//DedicatedCredhub credhub plan property, a credhub per service instance used as credential manager of atc.
+//Colocated it runs on the db instance group, otherwise the plan declares a credhub instance group
+type DedicatedCredhub struct {
+	Enabled  bool `json:"enabled"`
+	Colocate bool `json:"colocate"`
+}
+
+//credhubSecrets credhub authenticates atc by its client certificate, all certificates are signed by a CA of the instance
+type credhubSecrets struct {
+	address           string
+	colocated         bool
+	databaseHost      string
+	database          concourseDatabase
+	encryptionKey     string
+	serverCertificate Certificate
+	clientCertificate Certificate
+}
+
+func parseDedicatedCredhub(generation concourseGeneration, plan serviceadapter.Plan, externalDatabase *ExternalDatabase) (*DedicatedCredhub, error) {
+	credhub := &DedicatedCredhub{}
+	found, err := decodePlanProperty(plan.Properties, "credhub", credhub)
+	if err != nil || !found || !credhub.Enabled {
+		return nil, err
+	}
+	if generation == concourse3 {
+		return nil, fmt.Errorf("credhub: a dedicated credhub requires concourse 4 or later")
+	}
+	if _, ok := plan.Properties["credential_manager"]; ok {
+		return nil, fmt.Errorf("credhub: a dedicated credhub cannot be combined with credential_manager")
+	}
+	if credhub.Colocate && externalDatabase != nil {
+		return nil, fmt.Errorf("credhub: colocate requires the db instance group, the plan uses an external_database")
+	}
+	if !credhub.Colocate && findInstanceGroup(plan, CredhubInstanceName) == nil {
+		return nil, fmt.Errorf("credhub: the plan has no %s instance group, set colocate to run credhub on the db instance group", CredhubInstanceName)
+	}
+	return credhub, nil
+}
+
+//dedicatedCredhubEnabled invalid credhub properties are reported by parseDedicatedCredhub
+func dedicatedCredhubEnabled(planProperties serviceadapter.Properties) bool {
+	credhub := &DedicatedCredhub{}
+	found, err := decodePlanProperty(planProperties, "credhub", credhub)
+	return err == nil && found && credhub.Enabled
+}
+
+//previousCredhubProperty credhub properties live on the credhub or, when colocated, on the db instance group
+func previousCredhubProperty(previousManifest *bosh.BoshManifest, path ...interface{}) (string, bool) {
+	if value, found := previousStringProperty(previousManifest, CredhubInstanceName, propertyPath([]interface{}{"credhub"}, path...)...); found {
+		return value, true
+	}
+	return previousStringProperty(previousManifest, DatabaseInstanceName, propertyPath([]interface{}{"credhub"}, path...)...)
+}
+
+//generateCredhubSecrets the database password and encryption key follow the credential storage of the plan.
+//Certificates are always generated by the adapter, credhub only accepts client certificates with an app:<uuid>
+//organizational unit, which bosh variables cannot issue. They are renewed together when one is about to expire
+func generateCredhubSecrets(planConfig *PlanConfig, creds *credentials, deploymentName string, externalDatabase *ExternalDatabase, previousManifest *bosh.BoshManifest) (*credhubSecrets, error) {
+	config := planConfig.DedicatedCredhub
+	if config == nil {
+		return nil, nil
+	}
+	secrets := &credhubSecrets{colocated: config.Colocate}
+	if config.Colocate {
+		secrets.address = boshDNSAddress(DatabaseInstanceName, planConfig.Database.Networks, deploymentName)
+	} else {
+		secrets.address = boshDNSAddress(CredhubInstanceName, planConfig.Credhub.Networks, deploymentName)
+	}
+
+	var err error
+	previousEncryptionKey, found := previousCredhubProperty(previousManifest, "encryption", "keys", 0, "key_properties", "encryption_password")
+	secrets.encryptionKey, err = creds.passwordWithPrevious("credhub_encryption_password", previousEncryptionKey, found)
+	if err != nil {
+		return nil, err
+	}
+
+	previousPassword, found := previousCredhubProperty(previousManifest, "data_storage", "password")
+	if externalDatabase != nil {
+		name := externalDatabaseName(deploymentName)
+		if len(name) > 63-len("_credhub") {
+			name = name[:63-len("_credhub")]
+		}
+		name += "_credhub"
+		secrets.database = concourseDatabase{name: name, role: name, password: previousPassword, external: externalDatabase}
+		if !found {
+			secrets.database.password, err = CurrentPasswordGenerator()
+		}
+	} else {
+		secrets.database = concourseDatabase{name: CredhubDatabaseName, role: CredhubDatabaseName}
+		secrets.databaseHost = "127.0.0.1"
+		if !config.Colocate {
+			secrets.databaseHost = boshDNSAddress(DatabaseInstanceName, planConfig.Database.Networks, deploymentName)
+		}
+		secrets.database.password, err = creds.passwordWithPrevious("credhub_db_password", previousPassword, found)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if previousCredhubCertificates(secrets, previousManifest) {
+		return secrets, nil
+	}
+	ca, err := generateCertificateAuthority("credhub " + deploymentName + " CA")
+	if err != nil {
+		return nil, err
+	}
+	secrets.serverCertificate, err = issueCertificate(*ca, pkix.Name{CommonName: secrets.address}, []string{secrets.address}, x509.ExtKeyUsageServerAuth)
+	if err != nil {
+		return nil, err
+	}
+	appGUID, err := randomUUID()
+	if err != nil {
+		return nil, err
+	}
+	secrets.clientCertificate, err = issueCertificate(*ca, pkix.Name{
+		CommonName:         "concourse",
+		OrganizationalUnit: []string{"app:" + appGUID},
+	}, nil, x509.ExtKeyUsageClientAuth)
+	if err != nil {
+		return nil, err
+	}
+	return secrets, nil
+}
+
+//previousCredhubCertificates reads the certificates back unless they are about to expire or the address changed
+func previousCredhubCertificates(secrets *credhubSecrets, previousManifest *bosh.BoshManifest) bool {
+	ca, caFound := previousCredhubProperty(previousManifest, "authentication", "mutual_tls", "trusted_cas", 0)
+	serverCertificate, serverFound := previousCredhubProperty(previousManifest, "tls", "certificate")
+	serverKey, serverKeyFound := previousCredhubProperty(previousManifest, "tls", "private_key")
+	clientCertificate, clientFound := previousStringProperty(previousManifest, WebInstanceName, "credhub", "tls", "client_cert", "certificate")
+	clientKey, clientKeyFound := previousStringProperty(previousManifest, WebInstanceName, "credhub", "tls", "client_cert", "private_key")
+	if !caFound || !serverFound || !serverKeyFound || !clientFound || !clientKeyFound {
+		return false
+	}
+	if !certificateValidFor(serverCertificate, secrets.address) || !clientCertificateValid(clientCertificate) {
+		return false
+	}
+	secrets.serverCertificate = Certificate{Certificate: serverCertificate, PrivateKey: serverKey, CA: ca}
+	secrets.clientCertificate = Certificate{Certificate: clientCertificate, PrivateKey: clientKey, CA: ca}
+	return true
+}
+
+//credhubJobProperties credhub only trusts client certificates of its CA, uaa and acls are disabled
+func credhubJobProperties(secrets *credhubSecrets) map[string]interface{} {
+	dataStorage := map[string]interface{}{
+		"type":        "postgres",
+		"host":        secrets.databaseHost,
+		"port":        5432,
+		"database":    secrets.database.name,
+		"username":    secrets.database.role,
+		"password":    secrets.database.password,
+		"require_tls": false,
+	}
+	if external := secrets.database.external; external != nil {
+		dataStorage["host"], dataStorage["port"] = external.Host, external.Port
+		if external.CACert != "" {
+			dataStorage["require_tls"] = true
+			dataStorage["tls_ca"] = external.CACert
+		}
+	}
+	return map[string]interface{}{
+		"port": CredhubPort,
+		"tls": map[string]interface{}{
+			"certificate": secrets.serverCertificate.Certificate,
+			"private_key": secrets.serverCertificate.PrivateKey,
+		},
+		"authentication": map[string]interface{}{
+			"uaa": map[string]interface{}{"enabled": false},
+			"mutual_tls": map[string]interface{}{
+				"trusted_cas": []string{secrets.serverCertificate.CA},
+			},
+		},
+		"authorization": map[string]interface{}{
+			"acls": map[string]interface{}{"enabled": false},
+		},
+		"data_storage": dataStorage,
+		"encryption": map[string]interface{}{
+			"keys": []map[string]interface{}{{
+				"provider_name":  "internal",
+				"key_properties": map[string]interface{}{"encryption_password": secrets.encryptionKey},
+				"active":         true,
+			}},
+			"providers": []map[string]interface{}{{"name": "internal", "type": "internal"}},
+		},
+	}
+}
+
+//atcCredhubProperties atc uses the dedicated credhub as credential manager, authenticated by its client certificate
+func atcCredhubProperties(secrets *credhubSecrets) map[string]interface{} {
+	return map[string]interface{}{
+		"url":         fmt.Sprintf("https://%s:%d", secrets.address, CredhubPort),
+		"path_prefix": DefaultCredentialManagerPathPrefix,
+		"tls": map[string]interface{}{
+			"ca_cert": map[string]interface{}{"certificate": secrets.serverCertificate.CA},
+			"client_cert": map[string]interface{}{
+				"certificate": secrets.clientCertificate.Certificate,
+				"private_key": secrets.clientCertificate.PrivateKey,
+			},
+		},
+	}
+}
+
+//randomUUID version 4 uuid
+func randomUUID() (string, error) {
+	uuid := make([]byte, 16)
+	if _, err := rand.Read(uuid); err != nil {
+		return "", err
+	}
+	uuid[6] = (uuid[6] & 0x0f) | 0x40
+	uuid[8] = (uuid[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:]), nil
+}
@@ -0,0 +1,43 @@
+package adapter
+
+import (
+	"github.com/pivotal-cf/on-demand-services-sdk/bosh"
+	"github.com/pivotal-cf/on-demand-services-sdk/serviceadapter"
+)
+
+const (
+	//ErrandLifecycle lifecycle of instance groups run as odb lifecycle errands
+	ErrandLifecycle = "errand"
+	//ErrandsReleaseName release of this repository, built from release/ by scripts/release.sh, providing the errand jobs.
+	//Add it to the service_deployment releases of the broker on plans running errands
+	ErrandsReleaseName = "concourse-errands"
+)
+
+//errandInstanceGroup instance group of a lifecycle errand declared by the plan
+func errandInstanceGroup(planInstanceGroup *serviceadapter.InstanceGroup, jobs []bosh.Job, stemcellAlias string, properties map[string]interface{}) bosh.InstanceGroup {
+	return bosh.InstanceGroup{
+		Name:         planInstanceGroup.Name,
+		Lifecycle:    ErrandLifecycle,
+		Instances:    planInstanceGroup.Instances,
+		Jobs:         jobs,
+		VMType:       planInstanceGroup.VMType,
+		VMExtensions: planInstanceGroup.VMExtensions,
+		Stemcell:     stemcellAlias,
+		Networks:     mapNetworksToBoshNetworks(planInstanceGroup.Networks),
+		AZs:          planInstanceGroup.AZs,
+		Properties:   properties,
+	}
+}
+
+//concourseAPIProperties how errands reach atc, with the admin user of the main team
+func concourseAPIProperties(secrets concourseSecrets, externalURL string) map[string]interface{} {
+	properties := map[string]interface{}{
+		"url":      externalURL,
+		"username": AdminUsername,
+		"password": secrets.adminPassword,
+	}
+	if secrets.webCA != nil {
+		properties["ca_cert"] = secrets.webCA["certificate"]
+	}
+	return properties
+}
@@ -0,0 +1,136 @@
+package adapter
+
+import (
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/pivotal-cf/on-demand-services-sdk/serviceadapter"
+)
+
+//DefaultPostgresPort port of an external database when the plan does not set one
+const DefaultPostgresPort = 5432
+
+//ExternalDatabase operator managed postgres server, configured by the external_database plan property
+type ExternalDatabase struct {
+	Host          string `json:"host"`
+	Port          int    `json:"port"`
+	CACert        string `json:"ca_cert"`
+	AdminUsername string `json:"admin_username"`
+	AdminPassword string `json:"admin_password"`
+}
+
+//DatabaseProvisioner creates the database and role of a service instance on an external database.
+//Nothing drops them, the adapter is not called when an instance is deleted. After cf delete-service operators
+//drop the database and role concourse_<deployment name>, and concourse_<deployment name>_credhub on plans with credhub
+type DatabaseProvisioner interface {
+	EnsureDatabase(server ExternalDatabase, database string, role string, password string) error
+}
+
+var invalidIdentifierCharacters = regexp.MustCompile("[^a-z0-9_]")
+
+func parseExternalDatabase(planProperties serviceadapter.Properties) (*ExternalDatabase, error) {
+	externalDatabase := &ExternalDatabase{}
+	found, err := decodePlanProperty(planProperties, "external_database", externalDatabase)
+	if err != nil || !found {
+		return nil, err
+	}
+	if externalDatabase.Host == "" {
+		return nil, fmt.Errorf("external_database.host must be set")
+	}
+	if externalDatabase.AdminUsername == "" || externalDatabase.AdminPassword == "" {
+		return nil, fmt.Errorf("external_database.admin_username and external_database.admin_password must be set")
+	}
+	if externalDatabase.Port == 0 {
+		externalDatabase.Port = DefaultPostgresPort
+	}
+	return externalDatabase, nil
+}
+
+//externalDatabaseName database and role of a service instance, postgres identifiers are limited to 63 bytes
+func externalDatabaseName(deploymentName string) string {
+	name := "concourse_" + invalidIdentifierCharacters.ReplaceAllString(strings.ToLower(deploymentName), "_")
+	if len(name) > 63 {
+		name = name[:63]
+	}
+	return name
+}
+
+//databaseProvisioned the previous manifest already uses the database on the same server, updates leave the server alone.
+//Creating the instance, moving it to another server or to the external database provisions it
+func databaseProvisioned(database concourseDatabase, previousHost string, previousDatabase string) bool {
+	return previousHost == database.external.Host && previousDatabase == database.name
+}
+
+//PostgresProvisioner provisions databases by connecting with the admin credentials of the external database
+type PostgresProvisioner struct{}
+
+//EnsureDatabase creates or updates the role, then creates the database owned by it. Safe to call on every deploy
+func (p PostgresProvisioner) EnsureDatabase(server ExternalDatabase, database string, role string, password string) error {
+	connection, cleanup, err := p.connectionString(server)
+	defer cleanup()
+	if err != nil {
+		return err
+	}
+	db, err := sql.Open("postgres", connection)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var exists bool
+	if err = db.QueryRow("SELECT EXISTS (SELECT 1 FROM pg_roles WHERE rolname = $1)", role).Scan(&exists); err != nil {
+		return fmt.Errorf("looking up role %s: %s", role, err)
+	}
+	statement := "CREATE ROLE %s LOGIN PASSWORD %s"
+	if exists {
+		statement = "ALTER ROLE %s LOGIN PASSWORD %s"
+	}
+	if _, err = db.Exec(fmt.Sprintf(statement, pq.QuoteIdentifier(role), pq.QuoteLiteral(password))); err != nil {
+		return fmt.Errorf("creating role %s: %s", role, err)
+	}
+
+	if err = db.QueryRow("SELECT EXISTS (SELECT 1 FROM pg_database WHERE datname = $1)", database).Scan(&exists); err != nil {
+		return fmt.Errorf("looking up database %s: %s", database, err)
+	}
+	if exists {
+		return nil
+	}
+	if _, err = db.Exec(fmt.Sprintf("CREATE DATABASE %s OWNER %s", pq.QuoteIdentifier(database), pq.QuoteIdentifier(role))); err != nil {
+		return fmt.Errorf("creating database %s: %s", database, err)
+	}
+	return nil
+}
+
+//connectionString lib/pq only reads the root certificate from a file
+func (p PostgresProvisioner) connectionString(server ExternalDatabase) (string, func(), error) {
+	cleanup := func() {}
+	query := url.Values{}
+	query.Set("sslmode", "disable")
+	if server.CACert != "" {
+		caFile, err := ioutil.TempFile("", "external-database-ca")
+		if err != nil {
+			return "", cleanup, err
+		}
+		cleanup = func() { os.Remove(caFile.Name()) }
+		defer caFile.Close()
+		if _, err = caFile.WriteString(server.CACert); err != nil {
+			return "", cleanup, err
+		}
+		query.Set("sslmode", "verify-full")
+		query.Set("sslrootcert", caFile.Name())
+	}
+	connection := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(server.AdminUsername, server.AdminPassword),
+		Host:     fmt.Sprintf("%s:%d", server.Host, server.Port),
+		Path:     "/postgres",
+		RawQuery: query.Encode(),
+	}
+	return connection.String(), cleanup, nil
+}
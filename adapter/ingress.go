@@ -0,0 +1,149 @@
+package adapter
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/pivotal-cf/on-demand-services-sdk/bosh"
+	"github.com/pivotal-cf/on-demand-services-sdk/serviceadapter"
+)
+
+const (
+	//GorouterIngress atc is registered with the gorouter of cf_deployment at <deployment>.<app_domain>
+	GorouterIngress = "gorouter"
+	//LoadBalancerIngress the vm_extensions of the web instance group attach it to an IaaS load balancer
+	LoadBalancerIngress = "load_balancer"
+	//NoIngress atc is only reachable inside the bosh network through its bosh dns address
+	NoIngress = "none"
+	//ExternalURLDeploymentToken replaced by the deployment name in the external_url of load balancer ingress
+	ExternalURLDeploymentToken = "{deployment}"
+	//AtcPort plain http port of atc
+	AtcPort = 8080
+)
+
+//Ingress ingress plan property, gorouter when not set
+type Ingress struct {
+	Mode string `json:"mode"`
+	//ExternalURL template of the url of load balanced instances, e.g. https://{deployment}.ci.example.com
+	ExternalURL string `json:"external_url"`
+	//route cf_route settings of gorouter ingress
+	route        *CFRoute
+	cfDeployment string
+	appDomain    string
+}
+
+//webEndpoint where users reach the web instance group
+type webEndpoint struct {
+	mode         string
+	host         string
+	externalURL  string
+	cfDeployment string
+	//route how atc is registered with the gorouter, nil on other ingress
+	route *CFRoute
+	uris  []string
+}
+
+func parseIngress(planProperties serviceadapter.Properties) (*Ingress, error) {
+	ingress := &Ingress{}
+	if _, err := decodePlanProperty(planProperties, "ingress", ingress); err != nil {
+		return nil, err
+	}
+	if ingress.Mode == "" {
+		ingress.Mode = GorouterIngress
+	}
+	switch ingress.Mode {
+	case GorouterIngress:
+		ingress.cfDeployment, _ = planProperties["cf_deployment"].(string)
+		if ingress.cfDeployment == "" {
+			return nil, fmt.Errorf("ingress: cf_deployment must be set for gorouter ingress")
+		}
+		ingress.appDomain, _ = planProperties["app_domain"].(string)
+		if ingress.appDomain == "" {
+			return nil, fmt.Errorf("ingress: app_domain must be set for gorouter ingress")
+		}
+		route, err := parseCFRoute(planProperties)
+		if err != nil {
+			return nil, err
+		}
+		ingress.route = route
+	case LoadBalancerIngress:
+		if !strings.Contains(ingress.ExternalURL, ExternalURLDeploymentToken) {
+			return nil, fmt.Errorf("ingress.external_url must contain %s to tell instances apart", ExternalURLDeploymentToken)
+		}
+		parsed, err := url.Parse(strings.Replace(ingress.ExternalURL, ExternalURLDeploymentToken, "deployment", -1))
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return nil, fmt.Errorf("ingress.external_url must be an absolute http or https url")
+		}
+	case NoIngress:
+	default:
+		return nil, fmt.Errorf("unknown ingress.mode %q, must be %s, %s or %s", ingress.Mode, GorouterIngress, LoadBalancerIngress, NoIngress)
+	}
+	return ingress, nil
+}
+
+//endpoint host and url of the instance. Deployed instances keep their url while their ingress mode and tls stay
+//the same, users, oauth callbacks and bookmarks point at it. Changes to app_domain, cf_route or external_url
+//only apply to new instances, switching the ingress mode or tls moves the instance to the new url
+func (i *Ingress) endpoint(deploymentName string, webNetworks []string, tlsEnabled bool, previousManifest *bosh.BoshManifest) webEndpoint {
+	endpoint := webEndpoint{mode: i.Mode}
+	switch i.Mode {
+	case GorouterIngress:
+		endpoint.cfDeployment = i.cfDeployment
+		endpoint.route = i.route
+		endpoint.host = i.route.host(deploymentName, i.appDomain)
+		endpoint.externalURL = fmt.Sprintf("%s://%s", i.route.Scheme, endpoint.host)
+	case LoadBalancerIngress:
+		endpoint.externalURL = strings.Replace(i.ExternalURL, ExternalURLDeploymentToken, deploymentName, -1)
+		if parsed, err := url.Parse(endpoint.externalURL); err == nil {
+			endpoint.host = parsed.Hostname()
+		}
+	case NoIngress:
+		endpoint.host = boshDNSAddress(WebInstanceName, webNetworks, deploymentName)
+		endpoint.externalURL = fmt.Sprintf("http://%s:%d", endpoint.host, AtcPort)
+		if tlsEnabled {
+			endpoint.externalURL = fmt.Sprintf("https://%s:%d", endpoint.host, AtcTLSPort)
+		}
+	}
+	if previousURL, found := previousStringProperty(previousManifest, WebInstanceName, "external_url"); found {
+		mode, tls := previousIngress(previousManifest, previousURL)
+		if parsed, err := url.Parse(previousURL); err == nil && parsed.Host != "" && mode == i.Mode && tls == tlsEnabled {
+			endpoint.host, endpoint.externalURL = parsed.Hostname(), previousURL
+		}
+	}
+	if endpoint.route != nil {
+		endpoint.uris = append([]string{endpoint.host}, endpoint.route.additionalURIs(deploymentName)...)
+	}
+	return endpoint
+}
+
+//previousIngress ingress mode and tls of the previous manifest. Routed instances register with the gorouter,
+//instances without ingress use their bosh dns address
+func previousIngress(previousManifest *bosh.BoshManifest, previousURL string) (string, bool) {
+	_, tlsCert := previousProperty(previousManifest, WebInstanceName, "tls_cert")
+	_, tls := previousProperty(previousManifest, WebInstanceName, "tls", "cert")
+	tls = tls || tlsCert
+	if _, routed := previousProperty(previousManifest, WebInstanceName, "route_registrar"); routed {
+		return GorouterIngress, tls
+	}
+	if parsed, err := url.Parse(previousURL); err == nil && strings.HasSuffix(parsed.Hostname(), ".bosh") {
+		return NoIngress, tls
+	}
+	return LoadBalancerIngress, tls
+}
+
+//routed atc and the tsa tcp route are registered with the gorouter of the cf deployment
+func (e webEndpoint) routed() bool {
+	return e.mode == GorouterIngress
+}
+
+//withoutJob job names without the given job
+func withoutJob(jobNames []string, jobName string) []string {
+	filtered := []string{}
+	for _, name := range jobNames {
+		if name != jobName {
+			filtered = append(filtered, name)
+		}
+	}
+	return filtered
+}
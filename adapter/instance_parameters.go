@@ -0,0 +1,64 @@
+package adapter
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pivotal-cf/on-demand-services-sdk/serviceadapter"
+)
+
+//instanceParameters arbitrary parameters of cf create-service and update-service
+type instanceParameters struct {
+	mainTeam   *MainTeamAuth
+	localUsers []LocalUser
+	teams      []Team
+	pipeline   *BootstrapPipeline
+}
+
+//parseInstanceParameters validates every parameter before anything is generated, like parsePlanConfig all problems
+//are reported at once. Parameters not matching the schema are not parsed, without a plan config none are
+func parseInstanceParameters(generation concourseGeneration, plan serviceadapter.Plan, config *PlanConfig, arbitraryParams map[string]interface{}) (*instanceParameters, []string) {
+	problems := validateValue(instanceParameterSchema(plan), arbitraryParams, "")
+	if config == nil {
+		return nil, problems
+	}
+	schemaProblems := problems
+	parse := func(key string, parser func() error) {
+		for _, problem := range schemaProblems {
+			if strings.HasPrefix(problem, key+".") || strings.HasPrefix(problem, key+"[") || strings.HasPrefix(problem, key+" ") {
+				return
+			}
+		}
+		if err := parser(); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	params := &instanceParameters{}
+	parse("main_team", func() (err error) {
+		params.mainTeam, err = parseMainTeam(config.Auth, arbitraryParams)
+		return
+	})
+	parse("local_users", func() (err error) {
+		params.localUsers, err = parseLocalUsers(generation, arbitraryParams)
+		return
+	})
+	parse("teams", func() (err error) {
+		params.teams, err = parseTeams(generation, config.Auth, arbitraryParams)
+		return
+	})
+	if params.teams != nil && config.TeamsErrand == nil {
+		problems = append(problems, fmt.Sprintf("teams: the plan does not run the %s errand", TeamsErrandInstanceName))
+	}
+	parse("bootstrap_pipeline", func() (err error) {
+		params.pipeline, err = parseBootstrapPipeline(arbitraryParams)
+		return
+	})
+	if params.pipeline != nil && config.BootstrapErrand == nil {
+		problems = append(problems, fmt.Sprintf("bootstrap_pipeline: the plan does not run the %s errand", BootstrapErrandInstanceName))
+	}
+	if len(problems) > 0 {
+		return nil, problems
+	}
+	return params, nil
+}
@@ -0,0 +1,96 @@
+package adapter
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"math/big"
+)
+
+//CurrentKeyPairGenerator RSA key pair generator
+var CurrentKeyPairGenerator = rsaKeyPairGenerator
+
+//KeyPair PEM encoded RSA key pair, the public key is also provided in ssh authorized_keys format
+type KeyPair struct {
+	PrivateKey   string
+	PublicKey    string
+	SSHPublicKey string
+}
+
+func rsaKeyPairGenerator() (KeyPair, error) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Printf("Error generating rsa key, %v", err)
+		return KeyPair{}, err
+	}
+	publicKeyBytes, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		return KeyPair{}, err
+	}
+	return KeyPair{
+		PrivateKey: string(pem.EncodeToMemory(&pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(privateKey),
+		})),
+		PublicKey: string(pem.EncodeToMemory(&pem.Block{
+			Type:  "PUBLIC KEY",
+			Bytes: publicKeyBytes,
+		})),
+		SSHPublicKey: sshPublicKey(&privateKey.PublicKey),
+	}, nil
+}
+
+//keyPairFromPrivateKey restores the public keys of a PEM encoded RSA private key
+func keyPairFromPrivateKey(privateKeyPEM string) (KeyPair, error) {
+	block, _ := pem.Decode([]byte(privateKeyPEM))
+	if block == nil {
+		return KeyPair{}, fmt.Errorf("no PEM encoded private key found")
+	}
+	privateKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		return KeyPair{}, err
+	}
+	publicKeyBytes, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		return KeyPair{}, err
+	}
+	return KeyPair{
+		PrivateKey: privateKeyPEM,
+		PublicKey: string(pem.EncodeToMemory(&pem.Block{
+			Type:  "PUBLIC KEY",
+			Bytes: publicKeyBytes,
+		})),
+		SSHPublicKey: sshPublicKey(&privateKey.PublicKey),
+	}, nil
+}
+
+//sshPublicKey authorized_keys representation of the key (RFC 4253 ssh-rsa wire format)
+func sshPublicKey(publicKey *rsa.PublicKey) string {
+	keyType := "ssh-rsa"
+	buffer := &bytes.Buffer{}
+	writeSSHString(buffer, []byte(keyType))
+	writeSSHString(buffer, sshMPInt(big.NewInt(int64(publicKey.E))))
+	writeSSHString(buffer, sshMPInt(publicKey.N))
+	return keyType + " " + base64.StdEncoding.EncodeToString(buffer.Bytes())
+}
+
+func writeSSHString(buffer *bytes.Buffer, value []byte) {
+	length := make([]byte, 4)
+	binary.BigEndian.PutUint32(length, uint32(len(value)))
+	buffer.Write(length)
+	buffer.Write(value)
+}
+
+func sshMPInt(value *big.Int) []byte {
+	valueBytes := value.Bytes()
+	if len(valueBytes) > 0 && valueBytes[0]&0x80 != 0 {
+		return append([]byte{0}, valueBytes...)
+	}
+	return valueBytes
+}
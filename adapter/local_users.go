@@ -0,0 +1,67 @@
+package adapter
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/pivotal-cf/on-demand-services-sdk/bosh"
+)
+
+//LocalUser local_users parameter entry, an additional user of the main team
+type LocalUser struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+var validLocalUsername = regexp.MustCompile("^[a-zA-Z0-9_.-]+$")
+
+//parseLocalUsers users requested through the local_users parameter, nil when the parameter is not set
+func parseLocalUsers(generation concourseGeneration, arbitraryParams map[string]interface{}) ([]LocalUser, error) {
+	users := []LocalUser{}
+	requested, err := decodeParameter(arbitraryParams, "local_users", &users)
+	if err != nil || !requested {
+		return nil, err
+	}
+	if generation == concourse3 {
+		return nil, fmt.Errorf("local_users: additional local users require concourse 4 or later")
+	}
+	problems := []string{}
+	seen := map[string]bool{AdminUsername: true}
+	for _, user := range users {
+		if !validLocalUsername.MatchString(user.Username) {
+			problems = append(problems, fmt.Sprintf("local_users: username %q may only contain letters, digits, '_', '.' and '-'", user.Username))
+		}
+		if seen[user.Username] {
+			problems = append(problems, fmt.Sprintf("local_users: username %s is reserved or listed more than once", user.Username))
+		}
+		seen[user.Username] = true
+		if user.Password == "" {
+			problems = append(problems, fmt.Sprintf("local_users: password of %s must be set", user.Username))
+		}
+	}
+	if err = joinProblems(problems); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+//localUserEntries add_local_users entries and usernames of the main team, the admin user always comes first.
+//Without a local_users parameter the additional users of the previous manifest are kept
+func localUserEntries(adminPassword string, users []LocalUser, previousManifest *bosh.BoshManifest) (entries []string, usernames []string) {
+	entries, usernames = []string{fmt.Sprintf("%s:%s", AdminUsername, adminPassword)}, []string{AdminUsername}
+	if users == nil {
+		for _, entry := range previousLocalUsers(previousManifest, WebInstanceName) {
+			parts := strings.SplitN(entry, ":", 2)
+			if len(parts) == 2 && parts[0] != AdminUsername {
+				entries, usernames = append(entries, entry), append(usernames, parts[0])
+			}
+		}
+		return
+	}
+	for _, user := range users {
+		entries = append(entries, fmt.Sprintf("%s:%s", user.Username, user.Password))
+		usernames = append(usernames, user.Username)
+	}
+	return
+}
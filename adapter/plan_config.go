@@ -0,0 +1,161 @@
+package adapter
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/pivotal-cf/on-demand-services-sdk/serviceadapter"
+)
+
+//PlanConfig plan properties and instance groups decoded into their typed settings, defaults applied.
+//Optional settings and instance groups the plan does not define are nil
+type PlanConfig struct {
+	Web      serviceadapter.InstanceGroup
+	Database *serviceadapter.InstanceGroup
+	Credhub  *serviceadapter.InstanceGroup
+	Workers  []serviceadapter.InstanceGroup
+	//TeamsErrand, BootstrapErrand errands the plan runs
+	TeamsErrand     *serviceadapter.InstanceGroup
+	BootstrapErrand *serviceadapter.InstanceGroup
+
+	CredentialStorage string
+	CredhubPathPrefix string
+	ExternalDatabase  *ExternalDatabase
+	Ingress           *Ingress
+	TLS               *TLSConfig
+	UAA               *UAAConfig
+	Auth              *AuthConfig
+	CredentialManager *CredentialManager
+	DedicatedCredhub  *DedicatedCredhub
+	TSATCPRoute       *TSATCPRoute
+	Syslog            *Syslog
+	//TrustedCAs pem bundle servers of the plan are verified with unless their setting names its own ca_cert
+	TrustedCAs  string
+	WorkerPools map[string]WorkerPool
+}
+
+//parsePlanConfig validates the whole plan before anything is generated. Every problem is reported at once,
+//the operator fixes the plan in one go instead of one error per update-service. The config is nil when there are problems
+func parsePlanConfig(generation concourseGeneration, plan serviceadapter.Plan) (*PlanConfig, []string) {
+	config := &PlanConfig{}
+	problems := []string{}
+	check := func(err error) {
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	var err error
+	config.CredentialStorage, config.CredhubPathPrefix, err = parseCredentialStorage(plan.Properties)
+	check(err)
+	config.ExternalDatabase, err = parseExternalDatabase(plan.Properties)
+	check(err)
+	config.Ingress, err = parseIngress(plan.Properties)
+	check(err)
+	config.TLS, err = parseTLS(plan.Properties)
+	check(err)
+	config.UAA, err = parseUAA(plan.Properties)
+	check(err)
+	config.Auth, err = parsePlanAuth(generation, plan.Properties)
+	check(err)
+	config.CredentialManager, err = parseCredentialManager(plan.Properties)
+	check(err)
+	config.DedicatedCredhub, err = parseDedicatedCredhub(generation, plan, config.ExternalDatabase)
+	check(err)
+	config.TSATCPRoute, err = parseTSATCPRoute(plan.Properties)
+	check(err)
+	if config.TSATCPRoute != nil && config.Ingress != nil && config.Ingress.Mode != GorouterIngress {
+		problems = append(problems, fmt.Sprintf("tsa_tcp_route requires %s ingress", GorouterIngress))
+	}
+	if config.TLS != nil && config.TLS.Certificate == "" && config.TLS.CACertificate == "" && config.Ingress != nil && config.Ingress.Mode == GorouterIngress {
+		problems = append(problems, "tls: the gorouter only routes to backends with a certificate of a CA it trusts, set tls.ca_certificate and tls.ca_private_key to that CA or supply tls.certificate")
+	}
+	config.Syslog, err = parseSyslog(plan.Properties)
+	check(err)
+	config.TrustedCAs, err = parseTrustedCAs(plan.Properties)
+	check(err)
+	config.WorkerPools, err = parseWorkerPools(plan)
+	check(err)
+	config.applyTrustedCAs()
+
+	if web := findInstanceGroup(plan, WebInstanceName); web != nil {
+		config.Web = *web
+	} else {
+		problems = append(problems, fmt.Sprintf("plan has no %s instance group", WebInstanceName))
+	}
+	config.Database = findInstanceGroup(plan, DatabaseInstanceName)
+	if config.Database == nil && config.ExternalDatabase == nil {
+		problems = append(problems, fmt.Sprintf("plan has no %s instance group, set external_database to run without it", DatabaseInstanceName))
+	}
+	config.Workers = findWorkerPools(plan)
+	if len(config.Workers) == 0 {
+		problems = append(problems, fmt.Sprintf("plan has no %s instance group", WorkerInstanceName))
+	}
+	config.Credhub = findInstanceGroup(plan, CredhubInstanceName)
+	config.TeamsErrand = findInstanceGroup(plan, TeamsErrandInstanceName)
+	config.BootstrapErrand = findInstanceGroup(plan, BootstrapErrandInstanceName)
+
+	if len(problems) > 0 {
+		return nil, problems
+	}
+	return config, nil
+}
+
+//joinProblems a single error listing every problem, nil without problems
+func joinProblems(problems []string) error {
+	if len(problems) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(problems, "; "))
+}
+
+func parseTrustedCAs(planProperties serviceadapter.Properties) (string, error) {
+	value, ok := planProperties["trusted_cas"]
+	if !ok {
+		return "", nil
+	}
+	bundle, ok := value.(string)
+	if !ok || !x509.NewCertPool().AppendCertsFromPEM([]byte(bundle)) {
+		return "", fmt.Errorf("trusted_cas must be a PEM encoded certificate bundle")
+	}
+	return bundle, nil
+}
+
+//applyTrustedCAs settings naming their own ca_cert keep it
+func (c *PlanConfig) applyTrustedCAs() {
+	if c.TrustedCAs == "" {
+		return
+	}
+	caCerts := []*string{}
+	if c.Auth != nil && c.Auth.OIDC != nil {
+		caCerts = append(caCerts, &c.Auth.OIDC.CACert)
+	}
+	if c.Auth != nil && c.Auth.GitHub != nil && c.Auth.GitHub.Host != "" {
+		caCerts = append(caCerts, &c.Auth.GitHub.CACert)
+	}
+	if c.Auth != nil && c.Auth.LDAP != nil {
+		caCerts = append(caCerts, &c.Auth.LDAP.CACert)
+	}
+	if c.UAA != nil {
+		caCerts = append(caCerts, &c.UAA.CACert)
+	}
+	if c.CredentialManager != nil {
+		caCerts = append(caCerts, &c.CredentialManager.CACert)
+	}
+	if c.ExternalDatabase != nil {
+		caCerts = append(caCerts, &c.ExternalDatabase.CACert)
+	}
+	if c.TSATCPRoute != nil {
+		caCerts = append(caCerts, &c.TSATCPRoute.CACert)
+	}
+	if c.Syslog != nil && c.Syslog.TLS {
+		caCerts = append(caCerts, &c.Syslog.CACert)
+	}
+	for _, caCert := range caCerts {
+		if *caCert == "" {
+			*caCert = c.TrustedCAs
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package adapter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/pivotal-cf/on-demand-services-sdk/serviceadapter"
+)
+
+//decodePlanProperty decodes a structured plan property into target, reports whether the property is set
+func decodePlanProperty(planProperties serviceadapter.Properties, key string, target interface{}) (bool, error) {
+	value, ok := planProperties[key]
+	if !ok || value == nil {
+		return false, nil
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return true, fmt.Errorf("%s: %s", key, err)
+	}
+	if err := json.Unmarshal(encoded, target); err != nil {
+		return true, fmt.Errorf("%s: %s", key, err)
+	}
+	return true, nil
+}
+
+//decodeParameter decodes an arbitrary parameter into target. Tenants supply these, so unknown fields are rejected
+func decodeParameter(arbitraryParams map[string]interface{}, key string, target interface{}) (bool, error) {
+	value, ok := arbitraryParams[key]
+	if !ok || value == nil {
+		return false, nil
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return true, fmt.Errorf("%s: %s", key, err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(target); err != nil {
+		return true, fmt.Errorf("%s: %s", key, err)
+	}
+	return true, nil
+}
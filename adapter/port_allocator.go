@@ -0,0 +1,65 @@
+package adapter
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+//PortAllocator hands out the external tcp router port of a service instance
+type PortAllocator interface {
+	Allocate(deploymentName string, minPort int, maxPort int) (int, error)
+}
+
+//FilePortAllocator keeps a file per allocated port below Dir, holding the deployment name.
+//Ports are not freed when an instance is deleted, the adapter is not called on delete
+type FilePortAllocator struct {
+	Dir string
+}
+
+//Allocate returns the port already allocated to the deployment, or claims the lowest free port of the range
+func (a FilePortAllocator) Allocate(deploymentName string, minPort int, maxPort int) (int, error) {
+	if err := validatePathElement(deploymentName); err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(a.Dir, 0700); err != nil {
+		return 0, err
+	}
+	for port := minPort; port <= maxPort; port++ {
+		owner, err := ioutil.ReadFile(a.portPath(port))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		if strings.TrimSpace(string(owner)) == deploymentName {
+			return port, nil
+		}
+	}
+	for port := minPort; port <= maxPort; port++ {
+		file, err := os.OpenFile(a.portPath(port), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		_, err = file.WriteString(deploymentName)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return 0, err
+		}
+		return port, nil
+	}
+	return 0, fmt.Errorf("no free tcp router port left between %d and %d", minPort, maxPort)
+}
+
+func (a FilePortAllocator) portPath(port int) string {
+	return filepath.Join(a.Dir, strconv.Itoa(port))
+}
@@ -0,0 +1,133 @@
+package adapter
+
+import (
+	"strings"
+
+	"github.com/pivotal-cf/on-demand-services-sdk/bosh"
+)
+
+//findPreviousInstanceGroup returns the named instance group of the previous manifest, nil on fresh deployments
+func findPreviousInstanceGroup(previousManifest *bosh.BoshManifest, instanceGroupName string) *bosh.InstanceGroup {
+	if previousManifest == nil {
+		return nil
+	}
+	for _, instanceGroup := range previousManifest.InstanceGroups {
+		if instanceGroup.Name == instanceGroupName {
+			return &instanceGroup
+		}
+	}
+	return nil
+}
+
+//previousProperty walks the properties of an instance group of the previous manifest.
+//Path elements are either map keys (string) or list indexes (int)
+func previousProperty(previousManifest *bosh.BoshManifest, instanceGroupName string, path ...interface{}) (interface{}, bool) {
+	instanceGroup := findPreviousInstanceGroup(previousManifest, instanceGroupName)
+	if instanceGroup == nil {
+		return nil, false
+	}
+	return lookupProperty(instanceGroup.Properties, path...)
+}
+
+//previousStringProperty same as previousProperty but only returns non empty strings
+func previousStringProperty(previousManifest *bosh.BoshManifest, instanceGroupName string, path ...interface{}) (string, bool) {
+	value, found := previousProperty(previousManifest, instanceGroupName, path...)
+	if !found {
+		return "", false
+	}
+	str, ok := value.(string)
+	if !ok || str == "" {
+		return "", false
+	}
+	return str, true
+}
+
+//lookupProperty manifests read back from bosh are yaml decoded, so maps can be keyed by interface{} as well as string
+func lookupProperty(value interface{}, path ...interface{}) (interface{}, bool) {
+	current := value
+	for _, element := range path {
+		switch key := element.(type) {
+		case string:
+			switch properties := current.(type) {
+			case map[string]interface{}:
+				next, ok := properties[key]
+				if !ok {
+					return nil, false
+				}
+				current = next
+			case map[interface{}]interface{}:
+				next, ok := properties[key]
+				if !ok {
+					return nil, false
+				}
+				current = next
+			default:
+				return nil, false
+			}
+		case int:
+			switch list := current.(type) {
+			case []interface{}:
+				if key >= len(list) {
+					return nil, false
+				}
+				current = list[key]
+			case []map[interface{}]interface{}:
+				if key >= len(list) {
+					return nil, false
+				}
+				current = list[key]
+			case []map[string]interface{}:
+				if key >= len(list) {
+					return nil, false
+				}
+				current = list[key]
+			case []string:
+				if key >= len(list) {
+					return nil, false
+				}
+				current = list[key]
+			default:
+				return nil, false
+			}
+		default:
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+//propertyPath a copy of the path extended by the given elements
+func propertyPath(path []interface{}, elements ...interface{}) []interface{} {
+	return append(append([]interface{}{}, path...), elements...)
+}
+
+//previousLocalUserPassword password of the "username:password" entry of add_local_users
+func previousLocalUserPassword(previousManifest *bosh.BoshManifest, instanceGroupName string, username string) (string, bool) {
+	for _, entry := range previousLocalUsers(previousManifest, instanceGroupName) {
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) == 2 && parts[0] == username && parts[1] != "" {
+			return parts[1], true
+		}
+	}
+	return "", false
+}
+
+//previousLocalUsers "username:password" entries of add_local_users
+func previousLocalUsers(previousManifest *bosh.BoshManifest, instanceGroupName string) []string {
+	users, found := previousProperty(previousManifest, instanceGroupName, "add_local_users")
+	if !found {
+		return nil
+	}
+	entries := []string{}
+	switch list := users.(type) {
+	case []string:
+		entries = list
+	case []interface{}:
+		for _, entry := range list {
+			if str, ok := entry.(string); ok {
+				entries = append(entries, str)
+			}
+		}
+	}
+	return entries
+}
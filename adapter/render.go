@@ -0,0 +1,182 @@
+package adapter
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+
+	"github.com/pivotal-cf/on-demand-services-sdk/bosh"
+	"github.com/pivotal-cf/on-demand-services-sdk/serviceadapter"
+	yaml "gopkg.in/yaml.v2"
+)
+
+//DeploymentNamePrefix the broker names deployments service-instance_<instance id>
+const DeploymentNamePrefix = "service-instance_"
+
+//BrokerConfig parts of the on-demand-service-broker config.yml the broker builds the generate-manifest arguments from
+type BrokerConfig struct {
+	ServiceDeployment BrokerServiceDeployment `yaml:"service_deployment"`
+	ServiceCatalog    BrokerServiceCatalog    `yaml:"service_catalog"`
+}
+
+//BrokerServiceDeployment releases and stemcell every instance is deployed with
+type BrokerServiceDeployment struct {
+	Releases []BrokerRelease `yaml:"releases"`
+	Stemcell BrokerStemcell  `yaml:"stemcell"`
+}
+
+//BrokerRelease release of the service deployment and the jobs the adapter may use from it
+type BrokerRelease struct {
+	Name    string   `yaml:"name"`
+	Version string   `yaml:"version"`
+	Jobs    []string `yaml:"jobs"`
+}
+
+//BrokerStemcell stemcell of the service deployment
+type BrokerStemcell struct {
+	OS      string `yaml:"os"`
+	Version string `yaml:"version"`
+}
+
+//BrokerServiceCatalog global_properties apply to all plans, the properties of a plan override them
+type BrokerServiceCatalog struct {
+	ID               string                    `yaml:"id"`
+	GlobalProperties serviceadapter.Properties `yaml:"global_properties"`
+	Plans            []BrokerPlan              `yaml:"plans"`
+}
+
+//BrokerPlan plan of the service catalog
+type BrokerPlan struct {
+	Name           string                         `yaml:"name"`
+	ID             string                         `yaml:"plan_id"`
+	InstanceGroups []serviceadapter.InstanceGroup `yaml:"instance_groups"`
+	Properties     serviceadapter.Properties      `yaml:"properties"`
+	Update         *serviceadapter.Update         `yaml:"update"`
+}
+
+//RenderOptions the service instance a manifest is rendered for. PreviousManifest and PreviousPlanName
+//render an update, without them a new instance is rendered
+type RenderOptions struct {
+	PlanName         string
+	InstanceID       string
+	OrganizationGUID string
+	SpaceGUID        string
+	ArbitraryParams  map[string]interface{}
+	PreviousManifest *bosh.BoshManifest
+	PreviousPlanName string
+}
+
+//LoadBrokerConfig reads the config.yml of the broker
+func LoadBrokerConfig(path string) (BrokerConfig, error) {
+	config := BrokerConfig{}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return config, fmt.Errorf("reading broker config %s: %s", path, err)
+	}
+	if err = yaml.Unmarshal(content, &config); err != nil {
+		return config, fmt.Errorf("broker config %s is not valid yaml: %s", path, err)
+	}
+	return config, nil
+}
+
+//Render the manifest the broker would deploy. Nothing outside the adapter is touched, databases, uaa clients,
+//worker keys and credhub passwords are left alone and new instances are shown with the lowest port of their tsa_tcp_route range.
+//Secrets the previous manifest does not carry are generated afresh on every render
+func Render(config BrokerConfig, options RenderOptions, adapterConfigPath string, stderrLogger *log.Logger) (bosh.BoshManifest, error) {
+	plan, found := config.plan(options.PlanName)
+	if !found {
+		return bosh.BoshManifest{}, fmt.Errorf("broker config has no plan %s", options.PlanName)
+	}
+	var previousPlan *serviceadapter.Plan
+	if options.PreviousPlanName != "" {
+		previous, found := config.plan(options.PreviousPlanName)
+		if !found {
+			return bosh.BoshManifest{}, fmt.Errorf("broker config has no plan %s", options.PreviousPlanName)
+		}
+		previousPlan = &previous
+	}
+
+	serviceDeployment := serviceadapter.ServiceDeployment{
+		DeploymentName: DeploymentNamePrefix + options.InstanceID,
+		Stemcell: serviceadapter.Stemcell{
+			OS:      config.ServiceDeployment.Stemcell.OS,
+			Version: config.ServiceDeployment.Stemcell.Version,
+		},
+	}
+	for _, release := range config.ServiceDeployment.Releases {
+		serviceDeployment.Releases = append(serviceDeployment.Releases, serviceadapter.ServiceRelease{
+			Name:    release.Name,
+			Version: release.Version,
+			Jobs:    release.Jobs,
+		})
+	}
+
+	arbitraryParams := options.ArbitraryParams
+	if arbitraryParams == nil {
+		arbitraryParams = map[string]interface{}{}
+	}
+	requestParams := serviceadapter.RequestParameters{
+		"plan_id":    config.planID(options.PlanName),
+		"service_id": config.ServiceCatalog.ID,
+		"parameters": arbitraryParams,
+	}
+	if options.OrganizationGUID != "" {
+		requestParams["organization_guid"] = options.OrganizationGUID
+	}
+	if options.SpaceGUID != "" {
+		requestParams["space_guid"] = options.SpaceGUID
+	}
+
+	generator := ManifestGenerator{
+		StderrLogger:  stderrLogger,
+		ConfigPath:    adapterConfigPath,
+		PortAllocator: previewPortAllocator{},
+		SecretStore:   previewSecretStore{},
+	}
+	return generator.GenerateManifest(serviceDeployment, plan, requestParams, options.PreviousManifest, previousPlan)
+}
+
+//plan plan as the broker hands it to the adapter, global properties merged in
+func (c BrokerConfig) plan(name string) (serviceadapter.Plan, bool) {
+	for _, plan := range c.ServiceCatalog.Plans {
+		if plan.Name != name {
+			continue
+		}
+		properties := serviceadapter.Properties{}
+		for key, value := range c.ServiceCatalog.GlobalProperties {
+			properties[key] = jsonCompatible(value)
+		}
+		for key, value := range plan.Properties {
+			properties[key] = jsonCompatible(value)
+		}
+		return serviceadapter.Plan{
+			Properties:     properties,
+			InstanceGroups: plan.InstanceGroups,
+			Update:         plan.Update,
+		}, true
+	}
+	return serviceadapter.Plan{}, false
+}
+
+func (c BrokerConfig) planID(name string) string {
+	for _, plan := range c.ServiceCatalog.Plans {
+		if plan.Name == name {
+			return plan.ID
+		}
+	}
+	return ""
+}
+
+//previewPortAllocator claims nothing, renders show the first port of the range
+type previewPortAllocator struct{}
+
+func (previewPortAllocator) Allocate(deploymentName string, minPort int, maxPort int) (int, error) {
+	return minPort, nil
+}
+
+//previewSecretStore stores nothing, renders only show the references
+type previewSecretStore struct{}
+
+func (previewSecretStore) Store(server CredhubConfig, name string, variableType string, value interface{}) error {
+	return nil
+}
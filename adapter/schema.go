@@ -0,0 +1,246 @@
+package adapter
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/pivotal-cf/on-demand-services-sdk/serviceadapter"
+)
+
+//JSONSchemaDraft json schema version of the published plan schemas
+const JSONSchemaDraft = "http://json-schema.org/draft-04/schema#"
+
+//PlanSchema output of generate-plan-schemas, the service adapter sdk the adapter is built with predates plan schemas
+type PlanSchema struct {
+	ServiceInstance ServiceInstanceSchema `json:"service_instance"`
+	ServiceBinding  ServiceBindingSchema  `json:"service_binding"`
+}
+
+//ServiceInstanceSchema parameters of cf create-service and update-service
+type ServiceInstanceSchema struct {
+	Create JSONSchemas `json:"create"`
+	Update JSONSchemas `json:"update"`
+}
+
+//ServiceBindingSchema parameters of cf bind-service
+type ServiceBindingSchema struct {
+	Create JSONSchemas `json:"create"`
+}
+
+//JSONSchemas json schema of the arbitrary parameters
+type JSONSchemas struct {
+	Parameters map[string]interface{} `json:"parameters"`
+}
+
+//SchemaGenerator publishes the parameters accepted by cf create-service, update-service and bind-service -c
+type SchemaGenerator struct {
+	StderrLogger *log.Logger
+	//ConfigPath service-adapter.conf, login providers may be offered by its settings and profiles
+	ConfigPath string
+}
+
+//GeneratePlanSchema Contract of the broker on catalog requests, parameters of errands and login providers are only offered by plans running them
+func (s SchemaGenerator) GeneratePlanSchema(plan serviceadapter.Plan) (PlanSchema, error) {
+	plan, _, err := resolvePlan(s.ConfigPath, s.StderrLogger, plan, nil)
+	if err != nil {
+		return PlanSchema{}, err
+	}
+	instanceSchema := instanceParameterSchema(plan)
+	return PlanSchema{
+		ServiceInstance: ServiceInstanceSchema{
+			Create: JSONSchemas{Parameters: instanceSchema},
+			Update: JSONSchemas{Parameters: instanceSchema},
+		},
+		ServiceBinding: ServiceBindingSchema{
+			Create: JSONSchemas{Parameters: bindingParameterSchema()},
+		},
+	}, nil
+}
+
+//instanceParameterSchema parameters of create-service and update-service
+func instanceParameterSchema(plan serviceadapter.Plan) map[string]interface{} {
+	properties := map[string]interface{}{
+		"local_users": arraySchema(objectSchema(map[string]interface{}{
+			"username": typeSchema("string"),
+			"password": typeSchema("string"),
+		}, "username", "password")),
+	}
+	if allowed, _ := allowedTenantSettings(plan.Properties); containsString(allowed, "syslog") {
+		properties["syslog"] = objectSchema(map[string]interface{}{
+			"address":        typeSchema("string"),
+			"port":           typeSchema("integer"),
+			"transport":      typeSchema("string"),
+			"tls":            typeSchema("boolean"),
+			"permitted_peer": typeSchema("string"),
+			"ca_cert":        typeSchema("string"),
+		}, "address")
+	}
+	if mainTeam := memberSchema(plan.Properties); mainTeam != nil {
+		properties["main_team"] = mainTeam
+	}
+	if findInstanceGroup(plan, TeamsErrandInstanceName) != nil {
+		team := memberSchema(plan.Properties)
+		if team == nil {
+			team = objectSchema(map[string]interface{}{})
+		}
+		teamProperties := team["properties"].(map[string]interface{})
+		teamProperties["name"] = typeSchema("string")
+		teamProperties["role"] = typeSchema("string")
+		teamProperties["local"] = objectSchema(map[string]interface{}{
+			"users": arraySchema(typeSchema("string")),
+		})
+		team["required"] = []string{"name"}
+		properties["teams"] = arraySchema(team)
+	}
+	if findInstanceGroup(plan, BootstrapErrandInstanceName) != nil {
+		properties["bootstrap_pipeline"] = objectSchema(map[string]interface{}{
+			"name":   typeSchema("string"),
+			"config": typeSchema("string"),
+			"git": objectSchema(map[string]interface{}{
+				"uri":         typeSchema("string"),
+				"branch":      typeSchema("string"),
+				"path":        typeSchema("string"),
+				"private_key": typeSchema("string"),
+			}, "uri", "path"),
+			"vars": typeSchema("object"),
+		})
+	}
+	return parameterSchema(properties)
+}
+
+//bindingParameterSchema parameters of bind-service
+func bindingParameterSchema() map[string]interface{} {
+	return parameterSchema(map[string]interface{}{
+		"external_worker": typeSchema("boolean"),
+	})
+}
+
+//memberSchema members of the login providers offered by the plan, nil when it offers none
+func memberSchema(planProperties serviceadapter.Properties) map[string]interface{} {
+	auth := &AuthConfig{}
+	if found, err := decodePlanProperty(planProperties, "auth", auth); err != nil || !found {
+		return nil
+	}
+	groupMembers := objectSchema(map[string]interface{}{
+		"users":  arraySchema(typeSchema("string")),
+		"groups": arraySchema(typeSchema("string")),
+	})
+	providers := map[string]interface{}{}
+	if auth.OIDC != nil {
+		providers["oidc"] = groupMembers
+	}
+	if auth.GitHub != nil {
+		providers["github"] = objectSchema(map[string]interface{}{
+			"users": arraySchema(typeSchema("string")),
+			"orgs":  arraySchema(typeSchema("string")),
+			"teams": arraySchema(typeSchema("string")),
+		})
+	}
+	if auth.LDAP != nil {
+		providers["ldap"] = groupMembers
+	}
+	if len(providers) == 0 {
+		return nil
+	}
+	return objectSchema(providers)
+}
+
+func parameterSchema(properties map[string]interface{}) map[string]interface{} {
+	schema := objectSchema(properties)
+	schema["$schema"] = JSONSchemaDraft
+	return schema
+}
+
+func typeSchema(schemaType string) map[string]interface{} {
+	return map[string]interface{}{"type": schemaType}
+}
+
+func arraySchema(items map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"type": "array", "items": items}
+}
+
+//objectSchema object without additional properties
+func objectSchema(properties map[string]interface{}, required ...string) map[string]interface{} {
+	schema := map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+//validateParameters checks arbitrary parameters against the subset of json schema the adapter publishes
+func validateParameters(schema map[string]interface{}, params map[string]interface{}) error {
+	return joinProblems(validateValue(schema, params, ""))
+}
+
+//validateValue problems of the value and everything below it, every one is reported
+func validateValue(schema map[string]interface{}, value interface{}, path string) []string {
+	problems := []string{}
+	switch schema["type"] {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return []string{fmt.Sprintf("%s must be an object", path)}
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		if properties == nil {
+			return nil
+		}
+		keys := []string{}
+		for key := range object {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			propertySchema, known := properties[key].(map[string]interface{})
+			if !known {
+				if schema["additionalProperties"] == false {
+					problems = append(problems, fmt.Sprintf("%s is not accepted by this plan", joinPath(path, key)))
+				}
+				continue
+			}
+			if object[key] == nil {
+				continue
+			}
+			problems = append(problems, validateValue(propertySchema, object[key], joinPath(path, key))...)
+		}
+		required, _ := schema["required"].([]string)
+		for _, key := range required {
+			if object[key] == nil {
+				problems = append(problems, fmt.Sprintf("%s must be set", joinPath(path, key)))
+			}
+		}
+	case "array":
+		list, ok := value.([]interface{})
+		if !ok {
+			return []string{fmt.Sprintf("%s must be an array", path)}
+		}
+		items, _ := schema["items"].(map[string]interface{})
+		for index, item := range list {
+			problems = append(problems, validateValue(items, item, fmt.Sprintf("%s[%d]", path, index))...)
+		}
+	case "string":
+		if _, ok := value.(string); !ok {
+			problems = append(problems, fmt.Sprintf("%s must be a string", path))
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			problems = append(problems, fmt.Sprintf("%s must be a boolean", path))
+		}
+	case "integer":
+		if number, ok := value.(float64); !ok || number != float64(int(number)) {
+			problems = append(problems, fmt.Sprintf("%s must be an integer", path))
+		}
+	}
+	return problems
+}
+
+func joinPath(path string, key string) string {
+	return strings.TrimPrefix(path+"."+key, ".")
+}
@@ -0,0 +1,82 @@
+package adapter
+
+import (
+	"fmt"
+
+	"github.com/pivotal-cf/on-demand-services-sdk/bosh"
+	"github.com/pivotal-cf/on-demand-services-sdk/serviceadapter"
+)
+
+const (
+	//SyslogForwarderJobName job of syslog-release forwarding the logs of an instance group
+	SyslogForwarderJobName = "syslog_forwarder"
+	//DefaultSyslogPort port of the syslog target when the plan does not set one
+	DefaultSyslogPort = 514
+	//DefaultSyslogTransport transport of the syslog target when the plan does not set one
+	DefaultSyslogTransport = "tcp"
+)
+
+//Syslog syslog plan property, target the logs of all instance groups except errands are forwarded to
+type Syslog struct {
+	Address       string `json:"address"`
+	Port          int    `json:"port"`
+	Transport     string `json:"transport"`
+	TLS           bool   `json:"tls"`
+	PermittedPeer string `json:"permitted_peer"`
+	CACert        string `json:"ca_cert"`
+}
+
+func parseSyslog(planProperties serviceadapter.Properties) (*Syslog, error) {
+	syslog := &Syslog{}
+	found, err := decodePlanProperty(planProperties, "syslog", syslog)
+	if err != nil || !found {
+		return nil, err
+	}
+	if syslog.Address == "" {
+		return nil, fmt.Errorf("syslog.address must be set")
+	}
+	if syslog.Port == 0 {
+		syslog.Port = DefaultSyslogPort
+	}
+	if syslog.Transport == "" {
+		syslog.Transport = DefaultSyslogTransport
+	}
+	if syslog.Transport != "tcp" && syslog.Transport != "udp" && syslog.Transport != "relp" {
+		return nil, fmt.Errorf("syslog.transport must be tcp, udp or relp")
+	}
+	if syslog.TLS && syslog.Transport != "tcp" {
+		return nil, fmt.Errorf("syslog.tls requires tcp transport")
+	}
+	return syslog, nil
+}
+
+//addSyslogForwarder colocates the syslog forwarder with every instance group that is not an errand
+func addSyslogForwarder(syslog *Syslog, releases serviceadapter.ServiceReleases, instanceGroups []bosh.InstanceGroup) error {
+	if syslog == nil {
+		return nil
+	}
+	forwarder, err := gatherJobs(releases, SyslogForwarderJobName)
+	if err != nil {
+		return err
+	}
+	properties := map[string]interface{}{
+		"address":     syslog.Address,
+		"port":        syslog.Port,
+		"transport":   syslog.Transport,
+		"tls_enabled": syslog.TLS,
+	}
+	if syslog.PermittedPeer != "" {
+		properties["permitted_peer"] = syslog.PermittedPeer
+	}
+	if syslog.CACert != "" {
+		properties["ca_cert"] = syslog.CACert
+	}
+	for i := range instanceGroups {
+		if instanceGroups[i].Lifecycle == ErrandLifecycle {
+			continue
+		}
+		instanceGroups[i].Jobs = append(instanceGroups[i].Jobs, forwarder...)
+		instanceGroups[i].Properties["syslog"] = properties
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package adapter
+
+import (
+	"fmt"
+
+	"github.com/pivotal-cf/on-demand-services-sdk/bosh"
+	"github.com/pivotal-cf/on-demand-services-sdk/serviceadapter"
+)
+
+//TSATCPRoute tsa_tcp_route plan property, workers outside the deployment network reach the tsa through
+//the tcp router at domain:<external port>. The routing api client needs routing.routes.write
+type TSATCPRoute struct {
+	RouterGroup       string `json:"router_group"`
+	Domain            string `json:"domain"`
+	MinPort           int    `json:"min_port"`
+	MaxPort           int    `json:"max_port"`
+	ClientID          string `json:"client_id"`
+	ClientSecret      string `json:"client_secret"`
+	CACert            string `json:"ca_cert"`
+	SkipSSLValidation bool   `json:"skip_ssl_validation"`
+}
+
+//externalTSA tcp router address workers outside the deployment register through
+type externalTSA struct {
+	host  string
+	port  int
+	route *TSATCPRoute
+}
+
+func parseTSATCPRoute(planProperties serviceadapter.Properties) (*TSATCPRoute, error) {
+	route := &TSATCPRoute{}
+	found, err := decodePlanProperty(planProperties, "tsa_tcp_route", route)
+	if err != nil || !found {
+		return nil, err
+	}
+	if route.RouterGroup == "" || route.Domain == "" {
+		return nil, fmt.Errorf("tsa_tcp_route.router_group and tsa_tcp_route.domain must be set")
+	}
+	if route.MinPort <= 0 || route.MaxPort < route.MinPort || route.MaxPort > 65535 {
+		return nil, fmt.Errorf("tsa_tcp_route.min_port and tsa_tcp_route.max_port must be a valid port range")
+	}
+	if route.ClientID == "" || route.ClientSecret == "" {
+		return nil, fmt.Errorf("tsa_tcp_route.client_id and tsa_tcp_route.client_secret must be set")
+	}
+	return route, nil
+}
+
+//allocateExternalTSA the port of the previous manifest is kept while it lies in the range of the plan
+func (m ManifestGenerator) allocateExternalTSA(route *TSATCPRoute, deploymentName string, previousManifest *bosh.BoshManifest) (*externalTSA, error) {
+	if route == nil {
+		return nil, nil
+	}
+	if previous, found := previousProperty(previousManifest, WebInstanceName, "external_tsa", "port"); found {
+		if port, ok := intValue(previous); ok && port >= route.MinPort && port <= route.MaxPort {
+			return &externalTSA{host: route.Domain, port: port, route: route}, nil
+		}
+	}
+	if m.PortAllocator == nil {
+		return nil, fmt.Errorf("tsa_tcp_route: tcp routes are not supported by this broker")
+	}
+	port, err := m.PortAllocator.Allocate(deploymentName, route.MinPort, route.MaxPort)
+	if err != nil {
+		return nil, err
+	}
+	return &externalTSA{host: route.Domain, port: port, route: route}, nil
+}
+
+//tsaTCPRouteProperties route_registrar route of the tsa and the routing api client registering it
+func tsaTCPRouteProperties(external *externalTSA, registrationInterval string) (map[string]interface{}, map[string]interface{}) {
+	route := external.route
+	tcpRoute := map[string]interface{}{
+		"name":                  "concourse-tsa",
+		"type":                  "tcp",
+		"port":                  TsaPort,
+		"external_port":         external.port,
+		"router_group":          route.RouterGroup,
+		"registration_interval": registrationInterval,
+	}
+	routingAPI := map[string]interface{}{
+		"client_id":           route.ClientID,
+		"client_secret":       route.ClientSecret,
+		"skip_ssl_validation": route.SkipSSLValidation,
+	}
+	if route.CACert != "" {
+		routingAPI["ca_certs"] = []string{route.CACert}
+	}
+	return tcpRoute, routingAPI
+}
+
+func intValue(value interface{}) (int, bool) {
+	switch number := value.(type) {
+	case int:
+		return number, true
+	case int64:
+		return int(number), true
+	case uint64:
+		return int(number), true
+	case float64:
+		return int(number), number == float64(int(number))
+	}
+	return 0, false
+}
@@ -0,0 +1,177 @@
+package adapter
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/pivotal-cf/on-demand-services-sdk/bosh"
+)
+
+const (
+	//TeamsErrandInstanceName plan instance group running the teams errand, declare it with lifecycle errand and as post_deploy errand
+	TeamsErrandInstanceName = "teams"
+	//TeamsErrandJobName errand job of the concourse-errands release applying the teams the way fly set-team does
+	TeamsErrandJobName = "set-teams"
+	//DefaultTeamRole role of team members when the team does not name one
+	DefaultTeamRole = "member"
+)
+
+//Team teams parameter entry
+type Team struct {
+	Name   string         `json:"name"`
+	Role   string         `json:"role"`
+	Local  *LocalMembers  `json:"local"`
+	OIDC   *GroupMembers  `json:"oidc"`
+	GitHub *GitHubMembers `json:"github"`
+	LDAP   *GroupMembers  `json:"ldap"`
+}
+
+//LocalMembers local users of a team
+type LocalMembers struct {
+	Users []string `json:"users"`
+}
+
+var (
+	validTeamName = regexp.MustCompile("^[a-zA-Z0-9_-]+$")
+	teamRoles     = map[string]bool{"owner": true, "member": true, "pipeline-operator": true, "viewer": true}
+)
+
+//parseTeams teams requested through the teams parameter, nil when the parameter is not set.
+//Members may only come from local users and the login providers offered by the plan
+func parseTeams(generation concourseGeneration, auth *AuthConfig, arbitraryParams map[string]interface{}) ([]Team, error) {
+	teams := []Team{}
+	requested, err := decodeParameter(arbitraryParams, "teams", &teams)
+	if err != nil || !requested {
+		return nil, err
+	}
+	if generation == concourse3 {
+		return nil, fmt.Errorf("teams: teams require concourse 4 or later")
+	}
+	if auth == nil {
+		auth = &AuthConfig{}
+	}
+	problems := []string{}
+	check := func(err error) {
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	seen := map[string]bool{"main": true}
+	for i, team := range teams {
+		if !validTeamName.MatchString(team.Name) {
+			problems = append(problems, fmt.Sprintf("teams: name %q may only contain letters, digits, '_' and '-'", team.Name))
+		}
+		if seen[team.Name] {
+			problems = append(problems, fmt.Sprintf("teams: team %s is reserved or listed more than once", team.Name))
+		}
+		seen[team.Name] = true
+		if team.Role == "" {
+			teams[i].Role = DefaultTeamRole
+		} else if !teamRoles[team.Role] {
+			problems = append(problems, fmt.Sprintf("teams: role %q of team %s must be owner, member, pipeline-operator or viewer", team.Role, team.Name))
+		}
+		if team.Local == nil && team.OIDC == nil && team.GitHub == nil && team.LDAP == nil {
+			problems = append(problems, fmt.Sprintf("teams: team %s has no members", team.Name))
+		}
+		if team.Local != nil {
+			check(validateMembers(fmt.Sprintf("teams.%s.local.users", team.Name), team.Local.Users))
+		}
+		members := MainTeamAuth{OIDC: team.OIDC, GitHub: team.GitHub, LDAP: team.LDAP}
+		check(members.validate("teams."+team.Name, auth))
+	}
+	if err = joinProblems(problems); err != nil {
+		return nil, err
+	}
+	return teams, nil
+}
+
+//teamProperties a team in the config format of fly set-team
+func teamProperties(team Team) map[string]interface{} {
+	role := map[string]interface{}{"name": team.Role}
+	if team.Local != nil {
+		role["local"] = map[string]interface{}{"users": nonNil(team.Local.Users)}
+	}
+	if team.OIDC != nil {
+		role["oidc"] = groupMembersProperties(team.OIDC)
+	}
+	if team.GitHub != nil {
+		role["github"] = map[string]interface{}{
+			"users": nonNil(team.GitHub.Users),
+			"orgs":  nonNil(team.GitHub.Orgs),
+			"teams": nonNil(team.GitHub.Teams),
+		}
+	}
+	if team.LDAP != nil {
+		role["ldap"] = groupMembersProperties(team.LDAP)
+	}
+	return map[string]interface{}{
+		"name":  team.Name,
+		"roles": []map[string]interface{}{role},
+	}
+}
+
+//teamsErrandProperties without a teams parameter the teams of the previous manifest are applied again.
+//Teams dropped from the parameter are handed to the errand to be reported, destroying a team loses its pipelines
+func (m ManifestGenerator) teamsErrandProperties(teams []Team, secrets concourseSecrets, externalURL string, previousManifest *bosh.BoshManifest) map[string]interface{} {
+	previousTeams, _ := previousProperty(previousManifest, TeamsErrandInstanceName, "teams")
+	properties := map[string]interface{}{
+		"concourse":     concourseAPIProperties(secrets, externalURL),
+		"teams":         []map[string]interface{}{},
+		"removed_teams": []string{},
+	}
+	if teams == nil {
+		if previousTeams != nil {
+			properties["teams"] = previousTeams
+		}
+		if removed, found := previousProperty(previousManifest, TeamsErrandInstanceName, "removed_teams"); found {
+			properties["removed_teams"] = removed
+		}
+		return properties
+	}
+
+	requested := map[string]bool{}
+	teamList := []map[string]interface{}{}
+	for _, team := range teams {
+		requested[team.Name] = true
+		teamList = append(teamList, teamProperties(team))
+	}
+	properties["teams"] = teamList
+
+	removed := map[string]bool{}
+	for _, name := range previousTeamNames(previousManifest) {
+		if !requested[name] {
+			removed[name] = true
+		}
+	}
+	removedTeams := []string{}
+	for name := range removed {
+		removedTeams = append(removedTeams, name)
+	}
+	sort.Strings(removedTeams)
+	if len(removedTeams) > 0 {
+		m.StderrLogger.Printf("teams %v are no longer listed, they are kept and have to be destroyed through fly destroy-team", removedTeams)
+	}
+	properties["removed_teams"] = removedTeams
+	return properties
+}
+
+//previousTeamNames teams applied by the previous deploy and teams reported as removed before, until they are requested again
+func previousTeamNames(previousManifest *bosh.BoshManifest) []string {
+	names := []string{}
+	for i := 0; ; i++ {
+		name, found := previousStringProperty(previousManifest, TeamsErrandInstanceName, "teams", i, "name")
+		if !found {
+			break
+		}
+		names = append(names, name)
+	}
+	for i := 0; ; i++ {
+		name, found := previousStringProperty(previousManifest, TeamsErrandInstanceName, "removed_teams", i)
+		if !found {
+			break
+		}
+		names = append(names, name)
+	}
+	return names
+}
@@ -0,0 +1,129 @@
+package adapter
+
+import (
+	"fmt"
+
+	"github.com/pivotal-cf/on-demand-services-sdk/bosh"
+	"github.com/pivotal-cf/on-demand-services-sdk/serviceadapter"
+)
+
+//AtcTLSPort port atc serves https on, registered with the gorouter as backend tls port
+const AtcTLSPort = 4443
+
+//TLSConfig tls plan property. Without a certificate the adapter issues one for the host of the
+//instance, signed by the given CA or by a CA generated for the instance. The gorouter only routes to
+//backends signed by a CA it trusts, gorouter ingress therefore needs the certificate or the CA
+type TLSConfig struct {
+	Enabled       bool   `json:"enabled"`
+	Certificate   string `json:"certificate"`
+	PrivateKey    string `json:"private_key"`
+	CACertificate string `json:"ca_certificate"`
+	CAPrivateKey  string `json:"ca_private_key"`
+}
+
+func parseTLS(planProperties serviceadapter.Properties) (*TLSConfig, error) {
+	tls := &TLSConfig{}
+	found, err := decodePlanProperty(planProperties, "tls", tls)
+	if err != nil || !found {
+		return nil, err
+	}
+	if (tls.Certificate == "") != (tls.PrivateKey == "") {
+		return nil, fmt.Errorf("tls.certificate and tls.private_key must be set together")
+	}
+	if (tls.CACertificate == "") != (tls.CAPrivateKey == "") {
+		return nil, fmt.Errorf("tls.ca_certificate and tls.ca_private_key must be set together")
+	}
+	if !tls.Enabled && tls.Certificate == "" {
+		return nil, nil
+	}
+	return tls, nil
+}
+
+//previousCertificate certificate and key of the web instance group, tls_cert/tls_key on concourse 3
+func (g concourseGeneration) previousCertificate(previousManifest *bosh.BoshManifest) (string, string, bool) {
+	certificatePath, keyPath := []interface{}{"tls_cert"}, []interface{}{"tls_key"}
+	if g == concourse4 {
+		certificatePath, keyPath = []interface{}{"tls", "cert", "certificate"}, []interface{}{"tls", "cert", "private_key"}
+	}
+	certificate, certificateFound := previousStringProperty(previousManifest, WebInstanceName, certificatePath...)
+	key, keyFound := previousStringProperty(previousManifest, WebInstanceName, keyPath...)
+	return certificate, key, certificateFound && keyFound
+}
+
+//webCertificate certificate and private_key of atc and the CA clients verify it with, nil when the plan does
+//not enable tls. The CA is nil for operator certificates without a ca_certificate. A CA generated by the adapter
+//is kept with its private_key in the manifest and signs the renewed certificates
+func (c *credentials) webCertificate(tls *TLSConfig, host string, previous concourseGeneration) (map[string]interface{}, map[string]interface{}, error) {
+	if tls == nil {
+		return nil, nil, nil
+	}
+	var operatorCA map[string]interface{}
+	if tls.CACertificate != "" {
+		operatorCA = map[string]interface{}{"certificate": tls.CACertificate}
+	}
+	if tls.Certificate != "" {
+		return map[string]interface{}{
+			"certificate": tls.Certificate,
+			"private_key": tls.PrivateKey,
+		}, operatorCA, nil
+	}
+
+	if c.useCredhub() && tls.CACertificate == "" {
+		caName := c.declare("tls_ca", CertificateVariableType, map[string]interface{}{
+			"is_ca":       true,
+			"common_name": host + " CA",
+		})
+		certificateName := c.declare("tls_certificate", CertificateVariableType, map[string]interface{}{
+			"ca":                caName,
+			"common_name":       host,
+			"alternative_names": []string{host},
+		})
+		return map[string]interface{}{
+			"certificate": placeholder(certificateName + ".certificate"),
+			"private_key": placeholder(certificateName + ".private_key"),
+		}, map[string]interface{}{
+			"certificate": placeholder(caName + ".certificate"),
+		}, nil
+	}
+
+	ca, caProperties, err := c.webCertificateAuthority(tls, host)
+	if err != nil {
+		return nil, nil, err
+	}
+	if certificate, key, found := previous.previousCertificate(c.previousManifest); found && certificateValidFor(certificate, host) && certificateIssuedBy(certificate, ca.Certificate) {
+		return map[string]interface{}{
+			"certificate": certificate,
+			"private_key": key,
+		}, caProperties, nil
+	}
+	generated, err := CurrentCertificateGenerator(host, ca)
+	if err != nil {
+		return nil, nil, err
+	}
+	return map[string]interface{}{
+		"certificate": generated.Certificate,
+		"private_key": generated.PrivateKey,
+	}, caProperties, nil
+}
+
+//webCertificateAuthority the CA of the operator, else the CA generated for the instance until it is about to expire
+func (c *credentials) webCertificateAuthority(tls *TLSConfig, host string) (*CertificateAuthority, map[string]interface{}, error) {
+	if tls.CACertificate != "" {
+		return &CertificateAuthority{Certificate: tls.CACertificate, PrivateKey: tls.CAPrivateKey},
+			map[string]interface{}{"certificate": tls.CACertificate}, nil
+	}
+	ca := &CertificateAuthority{}
+	var certificateFound, keyFound bool
+	ca.Certificate, certificateFound = previousStringProperty(c.previousManifest, WebInstanceName, "tls_ca", "certificate")
+	ca.PrivateKey, keyFound = previousStringProperty(c.previousManifest, WebInstanceName, "tls_ca", "private_key")
+	if !certificateFound || !keyFound || !clientCertificateValid(ca.Certificate) {
+		var err error
+		if ca, err = generateCertificateAuthority(host + " CA"); err != nil {
+			return nil, nil, err
+		}
+	}
+	return ca, map[string]interface{}{
+		"certificate": ca.Certificate,
+		"private_key": ca.PrivateKey,
+	}, nil
+}
@@ -0,0 +1,253 @@
+package adapter
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pivotal-cf/on-demand-services-sdk/bosh"
+	"github.com/pivotal-cf/on-demand-services-sdk/serviceadapter"
+)
+
+//UAAConfig uaa plan property. The admin client registers an oauth client per service instance and needs clients.write
+type UAAConfig struct {
+	URL               string `json:"url"`
+	CFAPIURL          string `json:"cf_api_url"`
+	AdminClientID     string `json:"admin_client_id"`
+	AdminClientSecret string `json:"admin_client_secret"`
+	CACert            string `json:"ca_cert"`
+	SkipSSLValidation bool   `json:"skip_ssl_validation"`
+}
+
+//UAAClient oauth client atc authenticates cloud foundry users with
+type UAAClient struct {
+	ClientID             string   `json:"client_id"`
+	ClientSecret         string   `json:"client_secret,omitempty"`
+	Name                 string   `json:"name"`
+	Scope                []string `json:"scope"`
+	AuthorizedGrantTypes []string `json:"authorized_grant_types"`
+	RedirectURI          []string `json:"redirect_uri"`
+	Authorities          []string `json:"authorities"`
+	AutoApprove          []string `json:"autoapprove"`
+}
+
+//ClientRegistrar creates the uaa client of a service instance. Clients are not deleted with the instance,
+//the adapter is not called on delete-service. Operators remove the concourse-<deployment> client of a
+//deleted instance themselves, e.g. uaac client delete concourse-service-instance_<instance id>
+type ClientRegistrar interface {
+	EnsureClient(server UAAConfig, client UAAClient) error
+}
+
+//cfLogin uaa client and cloud foundry space the main team of an instance is mapped to
+type cfLogin struct {
+	config       *UAAConfig
+	client       UAAClient
+	clientSecret string
+	spaceGUID    string
+}
+
+func parseUAA(planProperties serviceadapter.Properties) (*UAAConfig, error) {
+	uaa := &UAAConfig{}
+	found, err := decodePlanProperty(planProperties, "uaa", uaa)
+	if err != nil || !found {
+		return nil, err
+	}
+	if uaa.URL == "" || uaa.CFAPIURL == "" {
+		return nil, fmt.Errorf("uaa.url and uaa.cf_api_url must be set")
+	}
+	if uaa.AdminClientID == "" || uaa.AdminClientSecret == "" {
+		return nil, fmt.Errorf("uaa.admin_client_id and uaa.admin_client_secret must be set")
+	}
+	uaa.URL = strings.TrimSuffix(uaa.URL, "/")
+	return uaa, nil
+}
+
+//generateCFLogin the client secret is always a literal, the adapter has to know it to register the client.
+//Updates do not necessarily carry the request context, the space is then read back from the previous manifest
+func generateCFLogin(generation concourseGeneration, uaa *UAAConfig, deploymentName string, externalURL string, requestParams serviceadapter.RequestParameters, previousManifest *bosh.BoshManifest) (*cfLogin, error) {
+	if uaa == nil {
+		return nil, nil
+	}
+	previousSecret, previousSpace := previousGeneration(previousManifest, generation).previousCFLogin(previousManifest)
+	spaceGUID := requestedSpaceGUID(requestParams)
+	if spaceGUID == "" {
+		spaceGUID = previousSpace
+	}
+	if spaceGUID == "" {
+		return nil, fmt.Errorf("uaa login requires the space_guid of the service instance")
+	}
+	clientSecret := previousSecret
+	if clientSecret == "" {
+		var err error
+		clientSecret, err = CurrentPasswordGenerator()
+		if err != nil {
+			return nil, err
+		}
+	}
+	callback := "/sky/issuer/callback"
+	if generation == concourse3 {
+		callback = "/auth/uaa/callback"
+	}
+	scope := []string{"openid", "cloud_controller.read"}
+	return &cfLogin{
+		config: uaa,
+		client: UAAClient{
+			ClientID:             "concourse-" + deploymentName,
+			ClientSecret:         clientSecret,
+			Name:                 "Concourse " + deploymentName,
+			Scope:                scope,
+			AuthorizedGrantTypes: []string{"authorization_code", "refresh_token"},
+			RedirectURI:          []string{externalURL + callback},
+			Authorities:          []string{"uaa.none"},
+			AutoApprove:          scope,
+		},
+		clientSecret: clientSecret,
+		spaceGUID:    spaceGUID,
+	}, nil
+}
+
+//requestedSpaceGUID space of the OSBAPI context, older brokers only send the top level space_guid
+func requestedSpaceGUID(requestParams serviceadapter.RequestParameters) string {
+	if context, ok := requestParams["context"].(map[string]interface{}); ok {
+		if spaceGUID, ok := context["space_guid"].(string); ok && spaceGUID != "" {
+			return spaceGUID
+		}
+	}
+	spaceGUID, _ := requestParams["space_guid"].(string)
+	return spaceGUID
+}
+
+//previousCFLogin client secret and space of the web instance group, uaa_auth on concourse 3.
+//Instances deployed before the settings moved to cf_auth carry them under cf
+func (g concourseGeneration) previousCFLogin(previousManifest *bosh.BoshManifest) (clientSecret string, spaceGUID string) {
+	if g == concourse4 {
+		var found bool
+		if clientSecret, found = previousStringProperty(previousManifest, WebInstanceName, "cf_auth", "client_secret"); !found {
+			clientSecret, _ = previousStringProperty(previousManifest, WebInstanceName, "cf", "client_secret")
+		}
+		spaceGUID, _ = previousStringProperty(previousManifest, WebInstanceName, "main_team", "auth", "cf", "space_guids", 0)
+		return
+	}
+	clientSecret, _ = previousStringProperty(previousManifest, WebInstanceName, "uaa_auth", "client_secret")
+	spaceGUID, _ = previousStringProperty(previousManifest, WebInstanceName, "uaa_auth", "cf_spaces", 0)
+	return
+}
+
+//UAAClientRegistrar registers clients through the uaa api
+type UAAClientRegistrar struct{}
+
+//EnsureClient creates the client, or updates it and its secret when it already exists. Safe to call on every deploy
+func (r UAAClientRegistrar) EnsureClient(server UAAConfig, client UAAClient) error {
+	httpClient, err := r.httpClient(server)
+	if err != nil {
+		return err
+	}
+	token, err := r.token(httpClient, server)
+	if err != nil {
+		return err
+	}
+
+	clientURL := fmt.Sprintf("%s/oauth/clients/%s", server.URL, url.PathEscape(client.ClientID))
+	status, err := r.do(httpClient, token, "GET", clientURL, nil)
+	if err != nil {
+		return err
+	}
+	switch status {
+	case http.StatusNotFound:
+		return r.expect(httpClient, token, "POST", server.URL+"/oauth/clients", client, http.StatusCreated)
+	case http.StatusOK:
+		secret := client.ClientSecret
+		client.ClientSecret = ""
+		if err = r.expect(httpClient, token, "PUT", clientURL, client, http.StatusOK); err != nil {
+			return err
+		}
+		return r.expect(httpClient, token, "PUT", clientURL+"/secret", map[string]string{"clientId": client.ClientID, "secret": secret}, http.StatusOK)
+	default:
+		return fmt.Errorf("looking up uaa client %s: unexpected status %d", client.ClientID, status)
+	}
+}
+
+func (r UAAClientRegistrar) httpClient(server UAAConfig) (*http.Client, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: server.SkipSSLValidation}
+	if server.CACert != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(server.CACert)) {
+			return nil, fmt.Errorf("uaa.ca_cert contains no PEM encoded certificate")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+	}, nil
+}
+
+func (r UAAClientRegistrar) token(httpClient *http.Client, server UAAConfig) (string, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	request, err := http.NewRequest("POST", server.URL+"/oauth/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	request.SetBasicAuth(server.AdminClientID, server.AdminClientSecret)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("requesting uaa token for %s: unexpected status %d", server.AdminClientID, response.StatusCode)
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err = json.NewDecoder(response.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	return token.AccessToken, nil
+}
+
+func (r UAAClientRegistrar) expect(httpClient *http.Client, token string, method string, requestURL string, body interface{}, expectedStatus int) error {
+	status, err := r.do(httpClient, token, method, requestURL, body)
+	if err != nil {
+		return err
+	}
+	if status != expectedStatus {
+		return fmt.Errorf("%s %s: unexpected status %d", method, requestURL, status)
+	}
+	return nil
+}
+
+func (r UAAClientRegistrar) do(httpClient *http.Client, token string, method string, requestURL string, body interface{}) (int, error) {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	request, err := http.NewRequest(method, requestURL, reader)
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Authorization", "Bearer "+token)
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Accept", "application/json")
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	io.Copy(ioutil.Discard, response.Body)
+	return response.StatusCode, nil
+}
@@ -0,0 +1,123 @@
+package adapter_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/datianshi/concourse-service-adapter/adapter"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("UAA client registrar", func() {
+	var (
+		uaa       *fakeUAA
+		server    *httptest.Server
+		registrar adapter.UAAClientRegistrar
+		config    adapter.UAAConfig
+		client    adapter.UAAClient
+	)
+
+	BeforeEach(func() {
+		uaa = &fakeUAA{clients: map[string]adapter.UAAClient{}, secrets: map[string]string{}}
+		server = httptest.NewServer(uaa)
+		config = adapter.UAAConfig{
+			URL:               server.URL,
+			AdminClientID:     "admin",
+			AdminClientSecret: "admin-secret",
+		}
+		client = adapter.UAAClient{
+			ClientID:     "concourse-some-instance-id",
+			ClientSecret: "client-secret",
+			Scope:        []string{"openid", "cloud_controller.read"},
+			RedirectURI:  []string{"https://some-instance-id.systemdomain.com/sky/issuer/callback"},
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("creates a missing client", func() {
+		Expect(registrar.EnsureClient(config, client)).To(Succeed())
+
+		Expect(uaa.clients).To(HaveKey("concourse-some-instance-id"))
+		Expect(uaa.clients["concourse-some-instance-id"].RedirectURI).To(Equal(client.RedirectURI))
+		Expect(uaa.secrets["concourse-some-instance-id"]).To(Equal("client-secret"))
+	})
+
+	It("updates an existing client and its secret", func() {
+		Expect(registrar.EnsureClient(config, client)).To(Succeed())
+		client.ClientSecret = "new-secret"
+		client.RedirectURI = []string{"https://moved.systemdomain.com/sky/issuer/callback"}
+
+		Expect(registrar.EnsureClient(config, client)).To(Succeed())
+		Expect(uaa.clients["concourse-some-instance-id"].RedirectURI).To(Equal(client.RedirectURI))
+		Expect(uaa.secrets["concourse-some-instance-id"]).To(Equal("new-secret"))
+	})
+
+	It("fails with wrong admin credentials", func() {
+		config.AdminClientSecret = "wrong"
+
+		Expect(registrar.EnsureClient(config, client)).To(MatchError(ContainSubstring("unexpected status 401")))
+		Expect(uaa.clients).To(BeEmpty())
+	})
+})
+
+//fakeUAA the parts of the uaa api the registrar talks to
+type fakeUAA struct {
+	sync.Mutex
+	clients map[string]adapter.UAAClient
+	secrets map[string]string
+}
+
+func (f *fakeUAA) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	if r.URL.Path == "/oauth/token" {
+		if id, secret, ok := r.BasicAuth(); !ok || id != "admin" || secret != "admin-secret" || r.FormValue("grant_type") != "client_credentials" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "admin-token"})
+		return
+	}
+	if r.Header.Get("Authorization") != "Bearer admin-token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	clientID := strings.TrimPrefix(r.URL.Path, "/oauth/clients/")
+	switch {
+	case r.Method == "POST" && r.URL.Path == "/oauth/clients":
+		var client adapter.UAAClient
+		json.NewDecoder(r.Body).Decode(&client)
+		f.clients[client.ClientID], f.secrets[client.ClientID] = client, client.ClientSecret
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == "PUT" && strings.HasSuffix(clientID, "/secret"):
+		clientID = strings.TrimSuffix(clientID, "/secret")
+		if _, ok := f.clients[clientID]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var change map[string]string
+		json.NewDecoder(r.Body).Decode(&change)
+		f.secrets[clientID] = change["secret"]
+	case clientID != r.URL.Path:
+		if _, ok := f.clients[clientID]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Method == "PUT" {
+			var client adapter.UAAClient
+			json.NewDecoder(r.Body).Decode(&client)
+			f.clients[clientID] = client
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
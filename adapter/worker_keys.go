@@ -0,0 +1,203 @@
+package adapter
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	workerKeyExtension = ".pub"
+	//WorkerKeysCredhubPath where CredhubWorkerKeyStore keeps the keys, below it a variable per deployment and binding
+	WorkerKeysCredhubPath = "/concourse-service-adapter/worker_keys"
+)
+
+//WorkerKeyStore public keys of external workers registered through bindings. Keys are authorized by
+//the next deploy of the service instance and revoked by the next deploy after the binding is deleted
+type WorkerKeyStore interface {
+	Add(deploymentName string, bindingID string, publicKey string) error
+	Remove(deploymentName string, bindingID string) error
+	List(deploymentName string) ([]string, error)
+}
+
+//CredhubVariables the credhub calls CredhubWorkerKeyStore makes
+type CredhubVariables interface {
+	SecretResolver
+	SecretStore
+	Delete(server CredhubConfig, name string) error
+	FindNames(server CredhubConfig, path string) ([]string, error)
+}
+
+//CredhubWorkerKeyStore keeps the keys in the director_credhub of the adapter config, they survive the broker vm
+//being recreated between the bind and the deploy that authorizes them
+type CredhubWorkerKeyStore struct {
+	StderrLogger *log.Logger
+	ConfigPath   string
+	Client       CredhubVariables
+}
+
+//Add stores the public key of a binding, external workers require director_credhub
+func (s CredhubWorkerKeyStore) Add(deploymentName string, bindingID string, publicKey string) error {
+	server, err := s.server()
+	if err != nil {
+		return err
+	}
+	if server == nil {
+		return fmt.Errorf("external workers require director_credhub in the adapter config to keep their keys")
+	}
+	name, err := s.keyName(deploymentName, bindingID)
+	if err != nil {
+		return err
+	}
+	return s.Client.Store(*server, name, ValueVariableType, publicKey)
+}
+
+//Remove deletes the key of a binding, bindings without a worker key are ignored
+func (s CredhubWorkerKeyStore) Remove(deploymentName string, bindingID string) error {
+	server, err := s.server()
+	if err != nil || server == nil {
+		return err
+	}
+	name, err := s.keyName(deploymentName, bindingID)
+	if err != nil {
+		return err
+	}
+	return s.Client.Delete(*server, name)
+}
+
+//List public keys of a deployment ordered by binding id, none without director_credhub
+func (s CredhubWorkerKeyStore) List(deploymentName string) ([]string, error) {
+	server, err := s.server()
+	if err != nil {
+		return nil, err
+	}
+	if server == nil {
+		return []string{}, nil
+	}
+	if err = validatePathElement(deploymentName); err != nil {
+		return nil, err
+	}
+	names, err := s.Client.FindNames(*server, WorkerKeysCredhubPath+"/"+deploymentName)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	keys := []string{}
+	for _, name := range names {
+		key, err := s.Client.Resolve(*server, name)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, strings.TrimSpace(fmt.Sprint(key)))
+	}
+	return keys, nil
+}
+
+func (s CredhubWorkerKeyStore) server() (*CredhubConfig, error) {
+	config, err := loadAdapterConfig(s.ConfigPath, s.StderrLogger)
+	if err != nil {
+		return nil, err
+	}
+	if config.Credhub == nil || s.Client == nil {
+		return nil, nil
+	}
+	return config.Credhub, nil
+}
+
+func (s CredhubWorkerKeyStore) keyName(deploymentName string, bindingID string) (string, error) {
+	for _, element := range []string{deploymentName, bindingID} {
+		if err := validatePathElement(element); err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("%s/%s/%s", WorkerKeysCredhubPath, deploymentName, bindingID), nil
+}
+
+//FileWorkerKeyStore keeps a file per binding below Dir/<deployment name>, the keys are lost with the disk
+type FileWorkerKeyStore struct {
+	Dir string
+}
+
+//Add stores the public key of a binding
+func (s FileWorkerKeyStore) Add(deploymentName string, bindingID string, publicKey string) error {
+	path, err := s.keyPath(deploymentName, bindingID)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, []byte(publicKey), 0600)
+}
+
+//Remove forgets the key of a binding, bindings without a worker key are ignored
+func (s FileWorkerKeyStore) Remove(deploymentName string, bindingID string) error {
+	path, err := s.keyPath(deploymentName, bindingID)
+	if err != nil {
+		return err
+	}
+	if err = os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+//List public keys of a deployment ordered by binding id, so the manifest does not change between deploys
+func (s FileWorkerKeyStore) List(deploymentName string) ([]string, error) {
+	dir, err := s.deploymentDir(deploymentName)
+	if err != nil {
+		return nil, err
+	}
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, file := range files {
+		if !file.IsDir() && strings.HasSuffix(file.Name(), workerKeyExtension) {
+			names = append(names, file.Name())
+		}
+	}
+	sort.Strings(names)
+	keys := []string{}
+	for _, name := range names {
+		key, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, strings.TrimSpace(string(key)))
+	}
+	return keys, nil
+}
+
+func (s FileWorkerKeyStore) deploymentDir(deploymentName string) (string, error) {
+	if err := validatePathElement(deploymentName); err != nil {
+		return "", err
+	}
+	return filepath.Join(s.Dir, deploymentName), nil
+}
+
+func (s FileWorkerKeyStore) keyPath(deploymentName string, bindingID string) (string, error) {
+	dir, err := s.deploymentDir(deploymentName)
+	if err != nil {
+		return "", err
+	}
+	if err = validatePathElement(bindingID); err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, bindingID+workerKeyExtension), nil
+}
+
+func validatePathElement(element string) error {
+	if element == "" || element == "." || element == ".." || strings.ContainsAny(element, `/\`) {
+		return fmt.Errorf("invalid name %q", element)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package adapter
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pivotal-cf/on-demand-services-sdk/bosh"
+	"github.com/pivotal-cf/on-demand-services-sdk/serviceadapter"
+)
+
+//WorkerPool concourse placement of a worker instance group, configured per pool by the worker_pools plan property
+type WorkerPool struct {
+	Tags []string `json:"tags"`
+	Team string   `json:"team"`
+}
+
+//isWorkerPool plans define pools as instance groups named worker or worker-<pool>
+func isWorkerPool(instanceGroupName string) bool {
+	return instanceGroupName == WorkerInstanceName || strings.HasPrefix(instanceGroupName, WorkerInstanceName+"-")
+}
+
+func findWorkerPools(plan serviceadapter.Plan) []serviceadapter.InstanceGroup {
+	pools := []serviceadapter.InstanceGroup{}
+	for _, instanceGroup := range plan.InstanceGroups {
+		if isWorkerPool(instanceGroup.Name) {
+			pools = append(pools, instanceGroup)
+		}
+	}
+	return pools
+}
+
+//parseWorkerPools tags and team of each pool, pools without an entry take any untagged work of all teams
+func parseWorkerPools(plan serviceadapter.Plan) (map[string]WorkerPool, error) {
+	pools := map[string]WorkerPool{}
+	if _, err := decodePlanProperty(plan.Properties, "worker_pools", &pools); err != nil {
+		return nil, err
+	}
+	for name := range pools {
+		if !isWorkerPool(name) || findInstanceGroup(plan, name) == nil {
+			return nil, fmt.Errorf("worker_pools.%s does not match a worker instance group of the plan", name)
+		}
+	}
+	return pools, nil
+}
+
+//previousWorkerPool name of a worker instance group of the previous manifest, all pools share the same worker key
+func previousWorkerPool(previousManifest *bosh.BoshManifest) string {
+	if previousManifest != nil {
+		for _, instanceGroup := range previousManifest.InstanceGroups {
+			if isWorkerPool(instanceGroup.Name) {
+				return instanceGroup.Name
+			}
+		}
+	}
+	return WorkerInstanceName
+}
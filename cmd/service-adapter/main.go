@@ -10,10 +10,30 @@ import (
 
 func main() {
 	stderrLogger := log.New(os.Stderr, "[concourse-service-adapter] ", log.LstdFlags)
+	configPath := "/var/vcap/jobs/service-adapter/config/service-adapter.conf"
+	if len(os.Args) > 1 && os.Args[1] == RenderCommand {
+		os.Exit(render(os.Args[2:], configPath, os.Stdout, os.Stderr))
+	}
+	if len(os.Args) > 1 && os.Args[1] == GeneratePlanSchemasCommand {
+		schemaGenerator := adapter.SchemaGenerator{StderrLogger: stderrLogger, ConfigPath: configPath}
+		os.Exit(generatePlanSchemas(os.Args[2:], schemaGenerator, os.Stdout, os.Stderr))
+	}
+	credhubClient := adapter.CredhubClient{}
+	workerKeyStore := adapter.CredhubWorkerKeyStore{StderrLogger: stderrLogger, ConfigPath: configPath, Client: credhubClient}
 	manifestGenerator := adapter.ManifestGenerator{
-		StderrLogger: stderrLogger,
-		ConfigPath:   "/var/vcap/jobs/service-adapter/config/service-adapter.conf",
+		StderrLogger:        stderrLogger,
+		ConfigPath:          configPath,
+		DatabaseProvisioner: adapter.PostgresProvisioner{},
+		WorkerKeyStore:      workerKeyStore,
+		ClientRegistrar:     adapter.UAAClientRegistrar{},
+		PortAllocator:       adapter.FilePortAllocator{Dir: "/var/vcap/store/service-adapter/tcp-ports"},
+		SecretStore:         credhubClient,
+	}
+	binder := adapter.Binder{
+		StderrLogger:   stderrLogger,
+		WorkerKeyStore: workerKeyStore,
+		ConfigPath:     configPath,
+		SecretResolver: credhubClient,
 	}
-	binder := adapter.Binder{StderrLogger: stderrLogger}
-	serviceadapter.HandleCommandLineInvocation(os.Args, manifestGenerator, binder, nil)
+	serviceadapter.HandleCommandLineInvocation(os.Args, manifestGenerator, binder, adapter.DashboardUrlGenerator{StderrLogger: stderrLogger})
 }
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/datianshi/concourse-service-adapter/adapter"
+	"github.com/pivotal-cf/on-demand-services-sdk/serviceadapter"
+)
+
+//GeneratePlanSchemasCommand the broker asks for plan schemas with it, the pinned sdk does not dispatch it
+const GeneratePlanSchemasCommand = "generate-plan-schemas"
+
+//generatePlanSchemas prints the schemas of the plan given with -plan-json, returns the exit code
+func generatePlanSchemas(args []string, generator adapter.SchemaGenerator, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet(GeneratePlanSchemasCommand, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	planJSON := flags.String("plan-json", "", "plan as the broker hands it to the adapter")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *planJSON == "" {
+		fmt.Fprintln(stderr, "usage: service-adapter generate-plan-schemas -plan-json <json>")
+		return 2
+	}
+
+	plan := serviceadapter.Plan{}
+	if err := json.Unmarshal([]byte(*planJSON), &plan); err != nil {
+		fmt.Fprintf(stderr, "-plan-json is not a valid plan: %s\n", err)
+		return 1
+	}
+	schema, err := generator.GeneratePlanSchema(plan)
+	if err != nil {
+		fmt.Fprintln(stdout, err)
+		return 1
+	}
+	if err = json.NewEncoder(stdout).Encode(schema); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	return 0
+}
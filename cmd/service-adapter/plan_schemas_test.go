@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/datianshi/concourse-service-adapter/adapter"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("generate-plan-schemas command", func() {
+	var (
+		stdout    *gbytes.Buffer
+		stderr    *gbytes.Buffer
+		generator adapter.SchemaGenerator
+	)
+
+	BeforeEach(func() {
+		stdout, stderr = gbytes.NewBuffer(), gbytes.NewBuffer()
+		generator = adapter.SchemaGenerator{}
+	})
+
+	It("prints the schemas of the plan as json", func() {
+		exitCode := generatePlanSchemas([]string{"-plan-json", `{"instance_groups": [{"name": "web"}], "properties": {}}`}, generator, stdout, stderr)
+
+		Expect(exitCode).To(Equal(0))
+		schema := map[string]interface{}{}
+		Expect(json.Unmarshal(stdout.Contents(), &schema)).To(Succeed())
+		Expect(schema).To(HaveKey("service_instance"))
+		Expect(schema["service_binding"]).To(HaveKey("create"))
+	})
+
+	It("rejects invalid plans", func() {
+		exitCode := generatePlanSchemas([]string{"-plan-json", "[]"}, generator, stdout, stderr)
+
+		Expect(exitCode).To(Equal(1))
+		Expect(stderr).To(gbytes.Say("-plan-json is not a valid plan"))
+	})
+
+	It("requires the plan", func() {
+		exitCode := generatePlanSchemas([]string{}, generator, stdout, stderr)
+
+		Expect(exitCode).To(Equal(2))
+		Expect(stderr).To(gbytes.Say("usage: service-adapter generate-plan-schemas"))
+	})
+})
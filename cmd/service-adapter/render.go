@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+
+	"github.com/datianshi/concourse-service-adapter/adapter"
+	"github.com/pivotal-cf/on-demand-services-sdk/bosh"
+	yaml "gopkg.in/yaml.v2"
+)
+
+//RenderCommand previews manifests offline, next to the commands the broker calls
+const RenderCommand = "render"
+
+const renderUsage = `usage: service-adapter render -broker-config <config.yml> -plan <name> [-params <json>] [-previous-manifest <file> [-previous-plan <name>]]
+
+Prints the manifest the broker would deploy for the plan. Nothing outside the adapter is touched: worker keys
+of bindings are not read, external databases are not provisioned, uaa clients are not registered and credhub
+passwords are not stored. New instances of plans with a tsa_tcp_route show the lowest port of the range.
+`
+
+//render prints the manifest the broker would deploy, returns the exit code
+func render(args []string, adapterConfigPath string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet(RenderCommand, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	brokerConfigPath := flags.String("broker-config", "", "config.yml of the on-demand-service-broker")
+	planName := flags.String("plan", "", "name of the plan in the service catalog")
+	params := flags.String("params", "{}", "arbitrary parameters as given to cf create-service -c")
+	instanceID := flags.String("instance-id", "preview", "service instance id, the deployment is named service-instance_<id>")
+	previousManifestPath := flags.String("previous-manifest", "", "manifest of the deployment to render an update of")
+	previousPlanName := flags.String("previous-plan", "", "plan the instance is updated from")
+	organizationGUID := flags.String("organization-guid", "", "organization of the service instance")
+	spaceGUID := flags.String("space-guid", "", "space of the service instance")
+	flags.StringVar(&adapterConfigPath, "adapter-config", adapterConfigPath, "service-adapter.conf with the operator defaults")
+	flags.Usage = func() {
+		fmt.Fprint(stderr, renderUsage)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *brokerConfigPath == "" || *planName == "" {
+		flags.Usage()
+		return 2
+	}
+
+	brokerConfig, err := adapter.LoadBrokerConfig(*brokerConfigPath)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	options := adapter.RenderOptions{
+		PlanName:         *planName,
+		InstanceID:       *instanceID,
+		OrganizationGUID: *organizationGUID,
+		SpaceGUID:        *spaceGUID,
+		PreviousPlanName: *previousPlanName,
+	}
+	if err = json.Unmarshal([]byte(*params), &options.ArbitraryParams); err != nil {
+		fmt.Fprintf(stderr, "-params must be a json object: %s\n", err)
+		return 1
+	}
+	if *previousManifestPath != "" {
+		content, err := ioutil.ReadFile(*previousManifestPath)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		options.PreviousManifest = &bosh.BoshManifest{}
+		if err = yaml.Unmarshal(content, options.PreviousManifest); err != nil {
+			fmt.Fprintf(stderr, "previous manifest %s is not valid yaml: %s\n", *previousManifestPath, err)
+			return 1
+		}
+	}
+
+	manifest, err := adapter.Render(brokerConfig, options, adapterConfigPath, log.New(stderr, "[concourse-service-adapter] ", log.LstdFlags))
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	content, err := yaml.Marshal(manifest)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	stdout.Write(content)
+	return 0
+}
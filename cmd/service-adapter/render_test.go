@@ -0,0 +1,69 @@
+package main
+
+import (
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("render command", func() {
+	var (
+		stdout           *gbytes.Buffer
+		stderr           *gbytes.Buffer
+		brokerConfigPath string
+	)
+
+	BeforeEach(func() {
+		stdout, stderr = gbytes.NewBuffer(), gbytes.NewBuffer()
+		brokerConfigPath = filepath.Join("..", "..", "adapter", "fixtures", "broker-config.yml")
+	})
+
+	It("prints the manifest of the plan in the broker config", func() {
+		exitCode := render([]string{"-broker-config", brokerConfigPath, "-plan", "small", "-instance-id", "some-instance-id", "-params", "{}"}, "", stdout, stderr)
+
+		Expect(exitCode).To(Equal(0))
+		Expect(stdout).To(gbytes.Say("name: service-instance_some-instance-id"))
+	})
+
+	It("fails for plans the broker config does not define", func() {
+		exitCode := render([]string{"-broker-config", brokerConfigPath, "-plan", "large"}, "", stdout, stderr)
+
+		Expect(exitCode).To(Equal(1))
+		Expect(stderr).To(gbytes.Say("broker config has no plan large"))
+	})
+
+	It("fails when the broker config cannot be read", func() {
+		exitCode := render([]string{"-broker-config", "missing.yml", "-plan", "small"}, "", stdout, stderr)
+
+		Expect(exitCode).To(Equal(1))
+		Expect(stderr).To(gbytes.Say("reading broker config missing.yml"))
+	})
+
+	It("rejects params that are not a json object", func() {
+		exitCode := render([]string{"-broker-config", brokerConfigPath, "-plan", "small", "-params", "[]"}, "", stdout, stderr)
+
+		Expect(exitCode).To(Equal(1))
+		Expect(stderr).To(gbytes.Say("-params must be a json object"))
+	})
+
+	It("prints the usage without the broker config and plan", func() {
+		exitCode := render([]string{"-plan", "small"}, "", stdout, stderr)
+
+		Expect(exitCode).To(Equal(2))
+		Expect(stderr).To(gbytes.Say("usage: service-adapter render"))
+		Expect(stdout.Contents()).To(BeEmpty())
+	})
+
+	It("tells what the preview leaves out", func() {
+		exitCode := render([]string{"-h"}, "", stdout, stderr)
+
+		Expect(exitCode).To(Equal(2))
+		Expect(stderr).To(gbytes.Say("worker keys"))
+		Expect(stderr).To(gbytes.Say("external databases are not provisioned"))
+		Expect(stderr).To(gbytes.Say("uaa clients are not registered"))
+		Expect(stderr).To(gbytes.Say("lowest port of the range"))
+		Expect(stderr).To(gbytes.Say("-broker-config"))
+	})
+})
@@ -0,0 +1,13 @@
+package main
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestServiceAdapter(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Service Adapter Command Suite")
+}
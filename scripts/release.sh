@@ -0,0 +1,8 @@
+#!/bin/bash
+# Builds the concourse-errands release providing the set-teams and set-pipeline errand jobs,
+# upload it and list it under service_deployment.releases of the broker.
+
+set -eu
+
+cd "$(dirname "$0")/../release"
+bosh create-release --force --tarball "${1:-/tmp/concourse-errands.tgz}"